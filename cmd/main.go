@@ -1,26 +1,74 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
 	"github.com/dotX12/traffic-guard/internal/logger"
+	"github.com/dotX12/traffic-guard/internal/metrics"
 	"github.com/dotX12/traffic-guard/internal/service"
+	"github.com/dotX12/traffic-guard/internal/service/aggregator"
 )
 
 var (
-	urls          []string
-	enableLogging bool
-	logLevel      string
-	version       = "dev" // Версия будет устанавливаться при сборке через -ldflags
+	urls               []string
+	enableLogging      bool
+	preferNetlinkIpset bool
+	geoDBPath          string
+	reloadConfigPath   string
+	logLevel           string
+	logFormat          string
+	logFilePath        string
+	logMaxSizeMB       int
+	logMaxAgeDays      int
+	logMaxBackups      int
+	dryRun             bool
+	updateURLs         []string
+	metricsListen      string
+	metricsURLs        []string
+	shipToURL          string
+	version            = "dev" // Версия будет устанавливаться при сборке через -ldflags
 )
 
+// loggerConfig builds a logger.Config from the --log-* flags: stderr is
+// always included, and a rotating file sink is added on top of it when
+// --log-file is set, so JSON audit records on disk coexist with the
+// human-readable stream.
+func loggerConfig() logger.Config {
+	cfg := logger.Config{
+		Format:  logger.Format(logFormat),
+		Outputs: []logger.Sink{logger.SinkStderr},
+	}
+
+	if logFilePath != "" {
+		cfg.Outputs = append(cfg.Outputs, logger.SinkFile)
+		cfg.File = logger.FileConfig{
+			Path:       logFilePath,
+			MaxSizeMB:  logMaxSizeMB,
+			MaxAgeDays: logMaxAgeDays,
+			MaxBackups: logMaxBackups,
+		}
+	}
+
+	return cfg
+}
+
 func main() {
 	// Setup logger
-	log := logger.New()
+	log, err := logger.New(loggerConfig())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	logger.SetGlobalLogger(log)
 
 	rootCmd := &cobra.Command{
@@ -29,15 +77,24 @@ func main() {
 		Long:    `Утилита для скачивания списков подсетей сканеров и настройки правил iptables/ipset для их блокировки.`,
 		Version: version,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			// Update logger level if specified
-			if logLevel != "" {
-				log = logger.NewWithLevel(logLevel)
-				logger.SetGlobalLogger(log)
+			// Rebuild the logger now that all persistent flags are parsed
+			newLog, err := logger.NewWithLevel(loggerConfig(), logLevel)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
 			}
+			log = newLog
+			logger.SetGlobalLogger(log)
 		},
 	}
 
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "console", "Формат логов: console или json")
+	rootCmd.PersistentFlags().StringVar(&logFilePath, "log-file", "", "Путь к файлу для записи JSON-логов в дополнение к stderr")
+	rootCmd.PersistentFlags().IntVar(&logMaxSizeMB, "log-max-size-mb", 100, "Максимальный размер файла логов в МБ перед ротацией")
+	rootCmd.PersistentFlags().IntVar(&logMaxAgeDays, "log-max-age-days", 7, "Максимальный возраст файлов логов в днях")
+	rootCmd.PersistentFlags().IntVar(&logMaxBackups, "log-max-backups", 3, "Количество хранимых архивных файлов логов")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Не выполнять команды iptables/ipset/systemctl, только логировать их")
 
 	fullCmd := &cobra.Command{
 		Use:   "full",
@@ -47,10 +104,68 @@ func main() {
 	}
 	fullCmd.Flags().StringSliceVarP(&urls, "urls", "u", []string{}, "Список URL для скачивания подсетей")
 	fullCmd.Flags().BoolVarP(&enableLogging, "enable-logging", "l", false, "Включить логирование заблокированных подключений")
+	fullCmd.Flags().BoolVar(&preferNetlinkIpset, "netlink-ipset", false, "Использовать netlink вместо exec ipset для заполнения наборов")
+	fullCmd.Flags().StringVar(&shipToURL, "ship-to", "", "Пересылать события из antiscan-aggregate во внешний приёмник: kafka://host:9092/topic, syslog+tls://host:port или http(s)://...")
 	fullCmd.MarkFlagRequired("urls")
 
 	rootCmd.AddCommand(fullCmd)
 
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Следить за позицией цепочки SCANNERS-BLOCK и восстанавливать её при сбросе",
+		Long:  `Запускает фоновый процесс, который держит правило "-j SCANNERS-BLOCK" на позиции 1 в INPUT/ufw-before-input/ufw6-before-input и переустанавливает его при сбросе правил (ufw reload, netfilter-persistent reload и т.п.).`,
+		Run:   runWatch,
+	}
+	rootCmd.AddCommand(watchCmd)
+
+	aggregateCmd := &cobra.Command{
+		Use:   "aggregate",
+		Short: "Агрегировать логи заблокированных подключений и обновить CSV с данными whois",
+		Long:  `Запускает фоновый процесс, который следит за логами iptables-scanners-ipv4/ipv6, считает количество обращений с каждого IP и периодически сохраняет сводку (с ASN/netname из bulk whois Team Cymru) в CSV.`,
+		Run:   runAggregate,
+	}
+	aggregateCmd.Flags().StringVar(&geoDBPath, "geo-db", "", "Путь к локальной базе MaxMind GeoLite2-ASN (если не указан, используется whois.cymru.com)")
+	aggregateCmd.Flags().StringVar(&reloadConfigPath, "config", "/etc/antiscan/config.yaml", "Путь к YAML-файлу, перечитываемому по SIGHUP (log_level, urls)")
+	aggregateCmd.Flags().StringVar(&shipToURL, "ship-to", "", "Пересылать события во внешний приёмник: kafka://host:9092/topic, syslog+tls://host:port или http(s)://...")
+	rootCmd.AddCommand(aggregateCmd)
+
+	uninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Удалить цепочки iptables, наборы ipset и конфигурацию логирования",
+		Long:  `Откатывает всё, что устанавливает "antiscan full": удаляет цепочку SCANNERS-BLOCK, наборы ipset, rsyslog/logrotate/systemd файлы и отключает сопутствующие сервисы.`,
+		Run:   runUninstall,
+	}
+	rootCmd.AddCommand(uninstallCmd)
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Показать текущее состояние ipset, iptables и сопутствующих сервисов",
+		Run:   runStatus,
+	}
+	rootCmd.AddCommand(statusCmd)
+
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Перекачать списки подсетей и применить изменения без затрагивания iptables/логирования",
+		Long:  `Скачивает подсети по --urls и приводит наборы ipset в соответствие с ними через ApplyDiff, не трогая цепочки iptables и конфигурацию логирования.`,
+		Run:   runUpdate,
+	}
+	updateCmd.Flags().StringSliceVarP(&updateURLs, "urls", "u", []string{}, "Список URL для скачивания подсетей")
+	updateCmd.MarkFlagRequired("urls")
+	rootCmd.AddCommand(updateCmd)
+
+	metricsCmd := &cobra.Command{
+		Use:   "metrics",
+		Short: "Запустить HTTP-эндпоинт с Prometheus-метриками заблокированного трафика",
+		Long:  `Запускает фоновый процесс, который агрегирует логи (как "antiscan aggregate"), периодически опрашивает ipset/iptables и отдаёт счётчики/gauge'и в формате Prometheus.`,
+		Run:   runMetrics,
+	}
+	metricsCmd.Flags().StringVar(&metricsListen, "listen", "127.0.0.1:9090", "Адрес, на котором отдавать /metrics")
+	metricsCmd.Flags().StringSliceVarP(&metricsURLs, "urls", "u", []string{}, "Список URL для скачивания подсетей (для antiscan_downloader_subnets_total)")
+	metricsCmd.Flags().StringVar(&geoDBPath, "geo-db", "", "Путь к локальной базе MaxMind GeoLite2-ASN (если не указан, используется whois.cymru.com)")
+	metricsCmd.Flags().StringVar(&shipToURL, "ship-to", "", "Пересылать события во внешний приёмник: kafka://host:9092/topic, syslog+tls://host:port или http(s)://...")
+	rootCmd.AddCommand(metricsCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -63,12 +178,18 @@ func runFull(cmd *cobra.Command, args []string) {
 	// Create services
 	// Create command service
 	cmdSvc := service.NewCommandService(log.Logger)
+	cmdSvc.SetDryRun(dryRun)
 
 	installer := service.NewInstallerService(log.Logger)
-	downloader := service.NewDownloader(log.Logger)
-	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc)
+	downloader := service.NewDownloader(log.Logger, "/var/lib/antiscan")
+	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc, preferNetlinkIpset)
 	iptablesSvc := service.NewIptablesService(log.Logger, cmdSvc, enableLogging)
-	loggingSvc := service.NewLoggingService(log.Logger)
+	loggingSvc := service.NewLoggingService(log.Logger, cmdSvc, shipToURL)
+
+	// On an nftables-only host, iptablesSvc's backend holds its own copy of
+	// set membership that ipsetSvc otherwise knows nothing about - keep it in
+	// sync on every Fill/ApplyDiff (see NetfilterRunner.SyncSet).
+	ipsetSvc.SetNetfilterRunner(iptablesSvc.Runner())
 
 	// Check root
 	if err := installer.CheckRootPrivileges(); err != nil {
@@ -108,11 +229,20 @@ func runFull(cmd *cobra.Command, args []string) {
 	}
 
 	// Download subnets
-	networks, err := downloader.Download(urls)
+	networks, _, err := downloader.Download(urls)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to download subnets")
 	}
 
+	// Collapse overlapping/adjacent CIDRs before populating ipset
+	ipv4Stats, ipv6Stats := networks.Aggregate()
+	log.Info().
+		Int("ipv4_before", ipv4Stats.Before).
+		Int("ipv4_after", ipv4Stats.After).
+		Int("ipv6_before", ipv6Stats.Before).
+		Int("ipv6_after", ipv6Stats.After).
+		Msg("Подсети агрегированы")
+
 	// Setup ipset
 	if err := ipsetSvc.Setup(); err != nil {
 		log.Fatal().Err(err).Msg("Failed to setup ipset")
@@ -128,6 +258,12 @@ func runFull(cmd *cobra.Command, args []string) {
 		log.Fatal().Err(err).Msg("Failed to setup iptables")
 	}
 
+	// Tell systemd (if this is running under a Type=notify unit) that
+	// startup has finished: the chain and ipsets are in place.
+	if err := service.NotifyReady(); err != nil {
+		log.Debug().Err(err).Msg("sd_notify недоступен")
+	}
+
 	// Setup logging if enabled
 	if enableLogging {
 		if err := loggingSvc.Setup(); err != nil {
@@ -145,6 +281,13 @@ func runFull(cmd *cobra.Command, args []string) {
 		log.Warn().Err(err).Msg("Failed to create ipset restore service")
 	}
 
+	// Create systemd service to keep SCANNERS-BLOCK at position 1
+	iptablesCmd := service.NewIptablesCommandService(log.Logger, cmdSvc)
+	reconciler := service.NewChainReconciler(log.Logger, cmdSvc, iptablesCmd, 5*time.Second, 2*time.Minute)
+	if err := reconciler.CreateWatchService(); err != nil {
+		log.Warn().Err(err).Msg("Failed to create antiscan-watch service")
+	}
+
 	if err := iptablesSvc.Save(); err != nil {
 		log.Error().Msg("╔════════════════════════════════════════════════════════════╗")
 		log.Error().Msg("║  ❌ УСТАНОВКА ПРЕРВАНА - КРИТИЧЕСКАЯ ОШИБКА                 ║")
@@ -155,3 +298,380 @@ func runFull(cmd *cobra.Command, args []string) {
 
 	log.Info().Msg("Полная установка успешно завершена")
 }
+
+func runWatch(cmd *cobra.Command, args []string) {
+	log := logger.Global()
+	log.Info().Msg("=== Запуск antiscan watch ===")
+
+	cmdSvc := service.NewCommandService(log.Logger)
+	cmdSvc.SetDryRun(dryRun)
+	iptablesCmd := service.NewIptablesCommandService(log.Logger, cmdSvc)
+	ipsetCmd := service.NewIpsetCommandService(log.Logger, cmdSvc)
+	iptablesSvc := service.NewIptablesService(log.Logger, cmdSvc, enableLogging)
+	reconciler := service.NewChainReconciler(log.Logger, cmdSvc, iptablesCmd, 5*time.Second, 2*time.Minute)
+	reconciler.SetChainRuleSet(iptablesSvc.DesiredRuleSet())
+	watchdog := service.NewWatchdogMonitor(log.Logger, ipsetCmd, iptablesCmd)
+
+	// Alongside ChainReconciler (which only keeps SCANNERS-BLOCK's jump at
+	// position 1), run the general-purpose drift reconciler against the
+	// chain's own LOG/DROP rules, so a wholesale "iptables -F"/"netfilter-
+	// persistent reload" that wipes the chain itself also gets repaired.
+	driftReconciler := service.NewReconcilerService(log.Logger, iptablesCmd, 2*time.Minute)
+	for _, rule := range iptablesSvc.DesiredRules() {
+		driftReconciler.Register(rule)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := watchdog.Run(ctx); err != nil {
+			log.Warn().Err(err).Msg("Watchdog остановился с ошибкой")
+		}
+	}()
+
+	go func() {
+		if err := driftReconciler.Run(ctx); err != nil && err != context.Canceled {
+			log.Warn().Err(err).Msg("Реконсилиатор дрейфа правил SCANNERS-BLOCK остановился с ошибкой")
+		}
+	}()
+
+	if err := service.NotifyReady(); err != nil {
+		log.Debug().Err(err).Msg("sd_notify недоступен")
+	}
+
+	err := reconciler.Run(ctx)
+
+	if notifyErr := service.NotifyStopping(); notifyErr != nil {
+		log.Debug().Err(notifyErr).Msg("sd_notify недоступен")
+	}
+
+	if err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("Реконсилиатор остановился с ошибкой")
+	}
+
+	log.Info().Msg("antiscan watch остановлен")
+}
+
+// reloadConfig is the small YAML file SIGHUP re-reads while antiscan
+// aggregate keeps running: a runtime log level and/or a refreshed blocklist
+// URL list, either of which may be omitted to leave that part untouched.
+type reloadConfig struct {
+	LogLevel string   `yaml:"log_level"`
+	Urls     []string `yaml:"urls"`
+}
+
+func loadReloadConfig(path string) (reloadConfig, error) {
+	var cfg reloadConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// newShipper builds the aggregator.RemoteShipper for --ship-to, or returns
+// a nil shipper (not an error) when the flag was left empty.
+func newShipper(log *logger.Logger, rawURL string) (aggregator.RemoteShipper, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	return aggregator.NewShipperFromURL(log.Logger, rawURL)
+}
+
+func runAggregate(cmd *cobra.Command, args []string) {
+	log := logger.Global()
+	log.Info().Msg("=== Запуск antiscan aggregate ===")
+
+	shipper, err := newShipper(log, shipToURL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Не удалось инициализировать --ship-to")
+	}
+
+	agg, err := aggregator.NewAggregator(log.Logger, aggregator.Config{
+		IPv4LogPath:    service.IPv4LogPath,
+		IPv6LogPath:    service.IPv6LogPath,
+		OutputCSVPath:  service.AggregateLogPath,
+		WhoisCachePath: service.WhoisCachePath,
+		GeoDBPath:      geoDBPath,
+		Interval:       30 * time.Second,
+		Shipper:        shipper,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Не удалось инициализировать агрегатор логов")
+	}
+	defer agg.Close()
+
+	cmdSvc := service.NewCommandService(log.Logger)
+	cmdSvc.SetDryRun(dryRun)
+	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc, preferNetlinkIpset)
+	iptablesSvc := service.NewIptablesService(log.Logger, cmdSvc, enableLogging)
+	ipsetSvc.SetNetfilterRunner(iptablesSvc.Runner())
+	downloader := service.NewDownloader(log.Logger, "/var/lib/antiscan")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reloadOnSIGHUP(log, downloader, ipsetSvc)
+			}
+		}
+	}()
+
+	if err := agg.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("Агрегатор логов остановился с ошибкой")
+	}
+
+	log.Info().Msg("antiscan aggregate остановлен")
+}
+
+// reloadOnSIGHUP re-reads reloadConfigPath and applies whatever changed:
+// a new log level takes effect immediately, and a new URL list triggers an
+// incremental ipset reconciliation that never drops existing connections
+// (see IpsetService.ApplyDiff).
+func reloadOnSIGHUP(log *logger.Logger, downloader *service.Downloader, ipsetSvc *service.IpsetService) {
+	log.Info().Msg("Получен SIGHUP, перечитываем конфигурацию")
+
+	cfg, err := loadReloadConfig(reloadConfigPath)
+	if err != nil {
+		log.Warn().Err(err).Str("path", reloadConfigPath).Msg("Не удалось прочитать файл конфигурации")
+		return
+	}
+
+	if cfg.LogLevel != "" {
+		logger.SetLevel(cfg.LogLevel)
+		log.Info().Str("level", cfg.LogLevel).Msg("Уровень логирования обновлён")
+	}
+
+	if len(cfg.Urls) == 0 {
+		return
+	}
+
+	networks, _, err := downloader.Download(cfg.Urls)
+	if err != nil {
+		log.Warn().Err(err).Msg("Не удалось скачать обновлённый список подсетей")
+		return
+	}
+	networks.Aggregate()
+
+	added, removed, err := ipsetSvc.ApplyDiff(networks)
+	if err != nil {
+		log.Warn().Err(err).Msg("Не удалось применить обновлённый список блокировок")
+		return
+	}
+
+	log.Info().
+		Int("added", added).
+		Int("removed", removed).
+		Msg("Список блокировок обновлён без разрыва соединений")
+}
+
+func runUninstall(cmd *cobra.Command, args []string) {
+	log := logger.Global()
+	log.Info().Msg("=== Удаление antiscan ===")
+
+	cmdSvc := service.NewCommandService(log.Logger)
+	cmdSvc.SetDryRun(dryRun)
+	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc, preferNetlinkIpset)
+	iptablesSvc := service.NewIptablesService(log.Logger, cmdSvc, enableLogging)
+	iptablesCmd := service.NewIptablesCommandService(log.Logger, cmdSvc)
+	loggingSvc := service.NewLoggingService(log.Logger, cmdSvc, "")
+	reconciler := service.NewChainReconciler(log.Logger, cmdSvc, iptablesCmd, 5*time.Second, 2*time.Minute)
+
+	if err := iptablesSvc.Teardown(); err != nil {
+		log.Warn().Err(err).Msg("Не удалось полностью удалить цепочки iptables")
+	}
+
+	if err := ipsetSvc.Teardown(); err != nil {
+		log.Warn().Err(err).Msg("Не удалось полностью удалить наборы ipset")
+	}
+
+	if err := loggingSvc.Teardown(); err != nil {
+		log.Warn().Err(err).Msg("Не удалось полностью удалить конфигурацию логирования")
+	}
+
+	if err := reconciler.RemoveWatchService(); err != nil {
+		log.Warn().Err(err).Msg("Не удалось удалить сервис antiscan-watch")
+	}
+
+	log.Info().Msg("antiscan удалён")
+}
+
+func runStatus(cmd *cobra.Command, args []string) {
+	log := logger.Global()
+
+	cmdSvc := service.NewCommandService(log.Logger)
+	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc, preferNetlinkIpset)
+	iptablesSvc := service.NewIptablesService(log.Logger, cmdSvc, enableLogging)
+
+	fmt.Println("=== antiscan status ===")
+
+	v4Count, v6Count, err := ipsetSvc.Counts()
+	if err != nil {
+		fmt.Printf("ipset: ошибка: %v\n", err)
+	} else {
+		fmt.Printf("ipset SCANNERS-BLOCK-V4: %d записей\n", v4Count)
+		fmt.Printf("ipset SCANNERS-BLOCK-V6: %d записей\n", v6Count)
+	}
+
+	v4Rules, v6Rules, err := iptablesSvc.RuleCounts()
+	if err != nil {
+		fmt.Printf("iptables: ошибка: %v\n", err)
+	} else {
+		fmt.Printf("iptables SCANNERS-BLOCK (IPv4): %d правил\n", v4Rules)
+		fmt.Printf("iptables SCANNERS-BLOCK (IPv6): %d правил\n", v6Rules)
+	}
+
+	for _, svc := range []string{"antiscan-watch.service", "antiscan-aggregate.service"} {
+		state := "inactive"
+		if cmdSvc.IsServiceActive(svc) {
+			state = "active"
+		}
+		enabled := "disabled"
+		if cmdSvc.IsServiceEnabled(svc) {
+			enabled = "enabled"
+		}
+		fmt.Printf("systemd %s: %s (%s)\n", svc, state, enabled)
+	}
+
+	if info, err := os.Stat(service.AggregateLogPath); err == nil {
+		fmt.Printf("Последняя агрегация логов: %s\n", info.ModTime().Format(time.RFC3339))
+	} else {
+		fmt.Printf("Последняя агрегация логов: нет данных (%s не найден)\n", service.AggregateLogPath)
+	}
+}
+
+func runUpdate(cmd *cobra.Command, args []string) {
+	log := logger.Global()
+	log.Info().Msg("=== Обновление списка блокировок ===")
+
+	cmdSvc := service.NewCommandService(log.Logger)
+	cmdSvc.SetDryRun(dryRun)
+	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc, preferNetlinkIpset)
+	iptablesSvc := service.NewIptablesService(log.Logger, cmdSvc, enableLogging)
+	ipsetSvc.SetNetfilterRunner(iptablesSvc.Runner())
+	downloader := service.NewDownloader(log.Logger, "/var/lib/antiscan")
+
+	networks, _, err := downloader.Download(updateURLs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to download subnets")
+	}
+	networks.Aggregate()
+
+	added, removed, err := ipsetSvc.ApplyDiff(networks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Не удалось применить обновлённый список подсетей")
+	}
+
+	log.Info().
+		Int("added", added).
+		Int("removed", removed).
+		Msg("Список блокировок обновлён без затрагивания iptables/логирования")
+}
+
+// ipsetSamplerInterval controls how often runMetrics refreshes
+// antiscan_ipset_entries from IpsetService.Counts (a full member listing of
+// each set) while the HTTP server is up.
+const ipsetSamplerInterval = 15 * time.Second
+
+func runMetrics(cmd *cobra.Command, args []string) {
+	log := logger.Global()
+	log.Info().Str("listen", metricsListen).Msg("=== Запуск antiscan metrics ===")
+
+	provider := metrics.NewPrometheusProvider()
+
+	cmdSvc := service.NewCommandService(log.Logger)
+	ipsetSvc := service.NewIpsetService(log.Logger, cmdSvc, preferNetlinkIpset)
+	downloader := service.NewDownloader(log.Logger, "/var/lib/antiscan")
+
+	if len(metricsURLs) > 0 {
+		if _, stats, err := downloader.Download(metricsURLs); err != nil {
+			log.Warn().Err(err).Msg("Не удалось скачать подсети для antiscan_downloader_subnets_total")
+		} else {
+			for _, stat := range stats {
+				provider.SetDownloaderSubnets(stat.URL, "all", float64(stat.SubnetsAdded))
+			}
+		}
+	}
+
+	shipper, err := newShipper(log, shipToURL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Не удалось инициализировать --ship-to")
+	}
+
+	agg, err := aggregator.NewAggregator(log.Logger, aggregator.Config{
+		IPv4LogPath:    service.IPv4LogPath,
+		IPv6LogPath:    service.IPv6LogPath,
+		OutputCSVPath:  service.AggregateLogPath,
+		WhoisCachePath: service.WhoisCachePath,
+		GeoDBPath:      geoDBPath,
+		Interval:       30 * time.Second,
+		Metrics:        provider,
+		Shipper:        shipper,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Не удалось инициализировать агрегатор логов")
+	}
+	defer agg.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: metricsListen, Handler: provider.Handler()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("HTTP-сервер метрик остановился с ошибкой")
+		}
+	}()
+
+	go sampleIpsetEntries(ctx, log, ipsetSvc, provider)
+
+	if err := agg.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("Агрегатор логов остановился с ошибкой")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Не удалось корректно остановить HTTP-сервер метрик")
+	}
+
+	log.Info().Msg("antiscan metrics остановлен")
+}
+
+// sampleIpsetEntries periodically refreshes antiscan_ipset_entries from the
+// live ipset sets until ctx is cancelled.
+func sampleIpsetEntries(ctx context.Context, log *logger.Logger, ipsetSvc *service.IpsetService, provider metrics.Provider) {
+	ticker := time.NewTicker(ipsetSamplerInterval)
+	defer ticker.Stop()
+
+	for {
+		v4, v6, err := ipsetSvc.Counts()
+		if err != nil {
+			log.Warn().Err(err).Msg("Не удалось получить размер наборов ipset")
+		} else {
+			provider.SetIpsetEntries("SCANNERS-BLOCK-V4", float64(v4))
+			provider.SetIpsetEntries("SCANNERS-BLOCK-V6", float64(v6))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
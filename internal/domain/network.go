@@ -1,5 +1,10 @@
 package domain
 
+import (
+	"net/netip"
+	"sort"
+)
+
 // Subnet represents a single IP subnet (CIDR notation)
 type Subnet struct {
 	CIDR   string
@@ -43,3 +48,127 @@ func (nl *NetworkList) IPv6Count() int {
 func (nl *NetworkList) TotalCount() int {
 	return nl.IPv4Count() + nl.IPv6Count()
 }
+
+// AggregateStats reports how many prefixes Aggregate removed for one
+// address family, so callers can log the reduction ratio.
+type AggregateStats struct {
+	Before int
+	After  int
+}
+
+// Aggregate collapses overlapping and adjacent CIDRs in place, replacing
+// IPv4Subnets and IPv6Subnets with a minimal covering set: a prefix
+// strictly contained in another kept prefix is dropped, and adjacent
+// sibling prefixes of the same length are merged into their shared parent,
+// repeating until a full pass makes no further changes. IPv4 and IPv6 are
+// aggregated independently. Entries that don't parse as CIDRs are left
+// untouched (appended back unchanged) rather than dropped.
+func (nl *NetworkList) Aggregate() (ipv4, ipv6 AggregateStats) {
+	nl.IPv4Subnets, ipv4 = aggregateSubnets(nl.IPv4Subnets)
+	nl.IPv6Subnets, ipv6 = aggregateSubnets(nl.IPv6Subnets)
+	return ipv4, ipv6
+}
+
+// aggregateSubnets runs the covering-set reduction over a single
+// address-family slice of CIDR strings.
+func aggregateSubnets(subnets []string) ([]string, AggregateStats) {
+	stats := AggregateStats{Before: len(subnets)}
+
+	prefixes := make([]netip.Prefix, 0, len(subnets))
+	var unparsed []string
+	for _, s := range subnets {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			unparsed = append(unparsed, s)
+			continue
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	prefixes = dropContained(sortPrefixes(prefixes))
+
+	for {
+		merged, changed := mergeAdjacent(prefixes)
+		if !changed {
+			break
+		}
+		prefixes = dropContained(sortPrefixes(merged))
+	}
+
+	result := make([]string, 0, len(prefixes)+len(unparsed))
+	for _, p := range prefixes {
+		result = append(result, p.String())
+	}
+	result = append(result, unparsed...)
+
+	stats.After = len(result)
+	return result, stats
+}
+
+// sortPrefixes orders prefixes by (address, prefix length ascending) so a
+// single linear pass can detect containment and adjacency.
+func sortPrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if cmp := prefixes[i].Addr().Compare(prefixes[j].Addr()); cmp != 0 {
+			return cmp < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+	return prefixes
+}
+
+// dropContained removes any prefix that falls entirely inside the
+// previously kept (and therefore broader-or-equal) prefix. prefixes must
+// already be sorted by sortPrefixes.
+func dropContained(prefixes []netip.Prefix) []netip.Prefix {
+	kept := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if len(kept) > 0 && kept[len(kept)-1].Contains(p.Addr()) && kept[len(kept)-1].Bits() <= p.Bits() {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// mergeAdjacent performs one pass collapsing consecutive sibling prefixes
+// of the same length (e.g. 1.2.3.0/25 + 1.2.3.128/25 -> 1.2.3.0/24) into
+// their shared parent prefix. prefixes must already be sorted.
+func mergeAdjacent(prefixes []netip.Prefix) ([]netip.Prefix, bool) {
+	merged := make([]netip.Prefix, 0, len(prefixes))
+	changed := false
+
+	i := 0
+	for i < len(prefixes) {
+		if i+1 < len(prefixes) {
+			if parent, ok := siblingParent(prefixes[i], prefixes[i+1]); ok {
+				merged = append(merged, parent)
+				i += 2
+				changed = true
+				continue
+			}
+		}
+		merged = append(merged, prefixes[i])
+		i++
+	}
+
+	return merged, changed
+}
+
+// siblingParent reports whether a and b are the two halves of the same
+// parent prefix (same length, sharing all but their last bit), returning
+// that parent prefix if so.
+func siblingParent(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 {
+		return netip.Prefix{}, false
+	}
+
+	parentBits := a.Bits() - 1
+	parentA := netip.PrefixFrom(a.Addr(), parentBits).Masked()
+	parentB := netip.PrefixFrom(b.Addr(), parentBits).Masked()
+	if parentA != parentB {
+		return netip.Prefix{}, false
+	}
+
+	return parentA, true
+}
@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregateDropsContained(t *testing.T) {
+	nl := &NetworkList{IPv4Subnets: []string{"10.0.0.0/8", "10.1.2.0/24"}}
+
+	stats, _ := nl.Aggregate()
+
+	want := []string{"10.0.0.0/8"}
+	if !reflect.DeepEqual(nl.IPv4Subnets, want) {
+		t.Fatalf("expected contained prefix to be dropped, got %v", nl.IPv4Subnets)
+	}
+	if stats.Before != 2 || stats.After != 1 {
+		t.Fatalf("expected stats {2 1}, got %+v", stats)
+	}
+}
+
+func TestAggregateMergesAdjacentSiblings(t *testing.T) {
+	nl := &NetworkList{IPv4Subnets: []string{"1.2.3.128/25", "1.2.3.0/25"}}
+
+	nl.Aggregate()
+
+	want := []string{"1.2.3.0/24"}
+	if !reflect.DeepEqual(nl.IPv4Subnets, want) {
+		t.Fatalf("expected siblings to merge into shared parent, got %v", nl.IPv4Subnets)
+	}
+}
+
+func TestAggregateMergesMultipleLevels(t *testing.T) {
+	// All four /26s of 1.2.3.0/24 are present - merging should cascade
+	// /26 -> /25 -> /24 in successive passes, not stop at the first merge.
+	nl := &NetworkList{IPv4Subnets: []string{
+		"1.2.3.0/26", "1.2.3.64/26", "1.2.3.128/26", "1.2.3.192/26",
+	}}
+
+	nl.Aggregate()
+
+	want := []string{"1.2.3.0/24"}
+	if !reflect.DeepEqual(nl.IPv4Subnets, want) {
+		t.Fatalf("expected a full cascade merge to /24, got %v", nl.IPv4Subnets)
+	}
+}
+
+func TestAggregatePassesThroughUnparsableEntries(t *testing.T) {
+	nl := &NetworkList{IPv4Subnets: []string{"10.0.0.0/8", "not-a-cidr"}}
+
+	stats, _ := nl.Aggregate()
+
+	if len(nl.IPv4Subnets) != 2 {
+		t.Fatalf("expected unparsable entry to survive untouched, got %v", nl.IPv4Subnets)
+	}
+	found := false
+	for _, s := range nl.IPv4Subnets {
+		if s == "not-a-cidr" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected \"not-a-cidr\" to be passed through, got %v", nl.IPv4Subnets)
+	}
+	if stats.Before != 2 || stats.After != 2 {
+		t.Fatalf("expected stats {2 2}, got %+v", stats)
+	}
+}
+
+func TestAggregateIPv4AndIPv6AreIndependent(t *testing.T) {
+	nl := &NetworkList{
+		IPv4Subnets: []string{"10.0.0.0/8", "10.1.0.0/16"},
+		IPv6Subnets: []string{"2001:db8::/32"},
+	}
+
+	ipv4Stats, ipv6Stats := nl.Aggregate()
+
+	if !reflect.DeepEqual(nl.IPv4Subnets, []string{"10.0.0.0/8"}) {
+		t.Fatalf("expected IPv4 prefixes to aggregate, got %v", nl.IPv4Subnets)
+	}
+	if !reflect.DeepEqual(nl.IPv6Subnets, []string{"2001:db8::/32"}) {
+		t.Fatalf("expected untouched IPv6 prefix, got %v", nl.IPv6Subnets)
+	}
+	if ipv4Stats.Before != 2 || ipv4Stats.After != 1 {
+		t.Fatalf("expected ipv4 stats {2 1}, got %+v", ipv4Stats)
+	}
+	if ipv6Stats.Before != 1 || ipv6Stats.After != 1 {
+		t.Fatalf("expected ipv6 stats {1 1}, got %+v", ipv6Stats)
+	}
+}
@@ -1,51 +1,205 @@
 package logger
 
 import (
+	"fmt"
+	"io"
+	"log/syslog"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/natefinch/lumberjack"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-// Logger wraps zerolog.Logger
+// Logger wraps zerolog.Logger. level is consulted by every writer on each
+// write, so SetLevel can change verbosity at runtime (e.g. from a SIGHUP
+// handler) without rebuilding or redistributing the Logger value itself.
 type Logger struct {
 	zerolog.Logger
+	level *Level
 }
 
-// New creates a new console logger with pretty output
-func New() *Logger {
-	output := zerolog.ConsoleWriter{
-		Out:        os.Stderr,
-		TimeFormat: time.RFC3339,
-		NoColor:    false,
+// Level is an atomically-updatable log level.
+type Level struct {
+	v atomic.Int32
+}
+
+func newLevel(initial zerolog.Level) *Level {
+	l := &Level{}
+	l.v.Store(int32(initial))
+	return l
+}
+
+// Get returns the current level.
+func (l *Level) Get() zerolog.Level {
+	return zerolog.Level(l.v.Load())
+}
+
+// Set updates the level from a string, using the same vocabulary as
+// --log-level; an unrecognized value falls back to info.
+func (l *Level) Set(level string) {
+	l.v.Store(int32(parseLevel(level)))
+}
+
+// SetLevel updates this logger's level at runtime.
+func (l *Logger) SetLevel(level string) {
+	l.level.Set(level)
+}
+
+// Format selects how records are rendered for the stderr sink. The file
+// sink is always JSON, since it exists to be durable and machine-readable.
+type Format string
+
+const (
+	FormatConsole Format = "console"
+	FormatJSON    Format = "json"
+)
+
+// Sink identifies one of the supported log destinations.
+type Sink string
+
+const (
+	SinkStderr Sink = "stderr"
+	SinkFile   Sink = "file"
+	SinkSyslog Sink = "syslog"
+)
+
+// FileConfig configures the rotating file sink, mirroring the size/age/
+// backup-count knobs of the logrotate policy antiscan already generates
+// for the iptables scanner logs (see LogrotateConfigTemplate).
+type FileConfig struct {
+	Path       string
+	MaxSizeMB  int
+	MaxAgeDays int
+	MaxBackups int
+}
+
+// Config controls New/NewWithLevel's output format and destinations.
+type Config struct {
+	Format  Format
+	Outputs []Sink
+	File    FileConfig
+}
+
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxAgeDays = 7
+	defaultMaxBackups = 3
+)
+
+// New creates a logger at info level per cfg.
+func New(cfg Config) (*Logger, error) {
+	return newLogger(cfg, zerolog.InfoLevel)
+}
+
+// NewWithLevel creates a logger at the given level per cfg.
+func NewWithLevel(cfg Config, level string) (*Logger, error) {
+	return newLogger(cfg, parseLevel(level))
+}
+
+func newLogger(cfg Config, level zerolog.Level) (*Logger, error) {
+	lvl := newLevel(level)
+
+	writers, err := buildWriters(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	logger := zerolog.New(output).
+	// The zerolog.Logger itself is left at TraceLevel so it never filters
+	// anything; the actual threshold lives in lvl and is applied per-write
+	// by levelFilterWriter wrapping the combined MultiLevelWriter, which is
+	// what makes it mutable after New returns.
+	filtered := &levelFilterWriter{level: lvl, out: zerolog.MultiLevelWriter(writers...)}
+
+	zl := zerolog.New(filtered).
+		Level(zerolog.TraceLevel).
 		With().
 		Timestamp().
 		Logger()
 
-	return &Logger{logger}
+	return &Logger{Logger: zl, level: lvl}, nil
+}
+
+// levelFilterWriter drops writes below the current value of level, checked
+// fresh on every call so a level change takes effect immediately.
+type levelFilterWriter struct {
+	level *Level
+	out   io.Writer
+}
+
+func (w *levelFilterWriter) Write(p []byte) (int, error) {
+	return w.out.Write(p)
+}
+
+func (w *levelFilterWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < w.level.Get() {
+		return len(p), nil
+	}
+	return w.out.Write(p)
 }
 
-// NewWithLevel creates a logger with specific level
-func NewWithLevel(level string) *Logger {
-	output := zerolog.ConsoleWriter{
+// buildWriters resolves cfg.Outputs into zerolog-compatible writers,
+// defaulting to a single pretty-printed stderr sink when none are given.
+func buildWriters(cfg Config) ([]io.Writer, error) {
+	outputs := cfg.Outputs
+	if len(outputs) == 0 {
+		outputs = []Sink{SinkStderr}
+	}
+
+	writers := make([]io.Writer, 0, len(outputs))
+	for _, sink := range outputs {
+		switch sink {
+		case SinkStderr:
+			writers = append(writers, stderrWriter(cfg.Format))
+
+		case SinkFile:
+			if cfg.File.Path == "" {
+				return nil, fmt.Errorf("file log sink requested but no path was configured")
+			}
+			writers = append(writers, &lumberjack.Logger{
+				Filename:   cfg.File.Path,
+				MaxSize:    valueOrDefault(cfg.File.MaxSizeMB, defaultMaxSizeMB),
+				MaxAge:     valueOrDefault(cfg.File.MaxAgeDays, defaultMaxAgeDays),
+				MaxBackups: valueOrDefault(cfg.File.MaxBackups, defaultMaxBackups),
+				Compress:   true,
+			})
+
+		case SinkSyslog:
+			w, err := syslog.New(syslog.LOG_INFO, "antiscan")
+			if err != nil {
+				return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+			}
+			writers = append(writers, zerolog.SyslogLevelWriter(w))
+
+		default:
+			return nil, fmt.Errorf("unknown log sink %q", sink)
+		}
+	}
+
+	return writers, nil
+}
+
+// stderrWriter renders pretty console output unless the caller asked for
+// raw JSON everywhere (cfg.Format == FormatJSON); the file sink above is
+// always JSON regardless of this setting.
+func stderrWriter(format Format) io.Writer {
+	if format == FormatJSON {
+		return os.Stderr
+	}
+	return zerolog.ConsoleWriter{
 		Out:        os.Stderr,
 		TimeFormat: time.RFC3339,
 		NoColor:    false,
 	}
+}
 
-	logLevel := parseLevel(level)
-
-	logger := zerolog.New(output).
-		Level(logLevel).
-		With().
-		Timestamp().
-		Logger()
-
-	return &Logger{logger}
+func valueOrDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
 }
 
 // parseLevel converts string to zerolog level
@@ -66,12 +220,26 @@ func parseLevel(level string) zerolog.Level {
 	}
 }
 
+// globalLevel is the level of whatever Logger was last passed to
+// SetGlobalLogger, retained so SetLevel can adjust verbosity after startup
+// without callers needing to hold onto the *Logger themselves.
+var globalLevel *Level
+
 // SetGlobalLogger sets the global logger instance
 func SetGlobalLogger(logger *Logger) {
 	log.Logger = logger.Logger
+	globalLevel = logger.level
+}
+
+// SetLevel updates the level of the current global logger at runtime, e.g.
+// from a SIGHUP handler re-reading configuration.
+func SetLevel(level string) {
+	if globalLevel != nil {
+		globalLevel.Set(level)
+	}
 }
 
 // Global returns the global logger
 func Global() *Logger {
-	return &Logger{log.Logger}
+	return &Logger{Logger: log.Logger, level: globalLevel}
 }
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PrometheusProvider implements Provider on top of a dedicated
+// prometheus.Registry (rather than the global DefaultRegisterer), so
+// antiscan metrics never picks up process/Go-runtime collectors registered
+// by some other package sharing the binary.
+type PrometheusProvider struct {
+	registry *prometheus.Registry
+
+	blockedPackets    *prometheus.CounterVec
+	ipsetEntries      *prometheus.GaugeVec
+	aggregateLastRun  prometheus.Gauge
+	downloaderSubnets *prometheus.GaugeVec
+}
+
+// NewPrometheusProvider creates a PrometheusProvider with all metrics
+// registered and ready to record.
+func NewPrometheusProvider() *PrometheusProvider {
+	registry := prometheus.NewRegistry()
+
+	p := &PrometheusProvider{
+		registry: registry,
+		blockedPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "antiscan_blocked_packets_total",
+			Help: "Packets matched by the SCANNERS-BLOCK chain, by address family, chain and source ASN.",
+		}, []string{"family", "chain", "src_asn"}),
+		ipsetEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "antiscan_ipset_entries",
+			Help: "Current number of entries in an ipset set.",
+		}, []string{"set"}),
+		aggregateLastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "antiscan_aggregate_last_run_timestamp",
+			Help: "Unix timestamp of the last successful log aggregation flush.",
+		}),
+		downloaderSubnets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "antiscan_downloader_subnets_total",
+			Help: "Number of subnets contributed by a downloaded blocklist URL, by address family.",
+		}, []string{"url", "family"}),
+	}
+
+	registry.MustRegister(p.blockedPackets, p.ipsetEntries, p.aggregateLastRun, p.downloaderSubnets)
+
+	return p
+}
+
+func (p *PrometheusProvider) IncBlockedPacket(family, chain, srcASN string) {
+	p.blockedPackets.WithLabelValues(family, chain, srcASN).Inc()
+}
+
+func (p *PrometheusProvider) SetIpsetEntries(set string, count float64) {
+	p.ipsetEntries.WithLabelValues(set).Set(count)
+}
+
+func (p *PrometheusProvider) SetAggregateLastRun(unixTime float64) {
+	p.aggregateLastRun.Set(unixTime)
+}
+
+func (p *PrometheusProvider) SetDownloaderSubnets(url, family string, count float64) {
+	p.downloaderSubnets.WithLabelValues(url, family).Set(count)
+}
+
+// Handler returns the HTTP handler that serves this provider's metrics in
+// the Prometheus exposition format.
+func (p *PrometheusProvider) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
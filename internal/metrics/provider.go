@@ -0,0 +1,29 @@
+// Package metrics exposes TrafficGuard's operational counters and gauges
+// behind a Provider interface, mirroring the NetfilterRunner/IpsetBackend/
+// Resolver split elsewhere in the codebase: callers depend on Provider,
+// not on Prometheus directly, so a statsd or OpenTelemetry backend can be
+// swapped in later without touching the aggregator or cmd/main.go.
+package metrics
+
+// Provider is implemented by every metrics backend TrafficGuard can export
+// to. Labels match the Prometheus metric names this package was built
+// around (antiscan_blocked_packets_total, antiscan_ipset_entries,
+// antiscan_aggregate_last_run_timestamp, antiscan_downloader_subnets_total).
+type Provider interface {
+	// IncBlockedPacket records one matched SCANNERS-BLOCK packet for the
+	// given address family ("ipv4"/"ipv6"), chain, and source ASN ("UNKNOWN"
+	// until the aggregator's resolver has looked it up).
+	IncBlockedPacket(family, chain, srcASN string)
+
+	// SetIpsetEntries reports the current member count of an ipset set.
+	SetIpsetEntries(set string, count float64)
+
+	// SetAggregateLastRun reports the Unix timestamp of the most recent
+	// successful aggregation flush.
+	SetAggregateLastRun(unixTime float64)
+
+	// SetDownloaderSubnets reports how many subnets a downloaded blocklist
+	// URL contributed, under the given family label. runMetrics reports one
+	// combined count per URL with family "all"; it has no per-family split.
+	SetDownloaderSubnets(url, family string, count float64)
+}
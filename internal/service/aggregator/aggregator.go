@@ -0,0 +1,245 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/dotX12/traffic-guard/internal/metrics"
+)
+
+// blockedChainName is the iptables chain the aggregator's log lines are
+// produced by; kept as a literal here rather than importing the service
+// package's unexported chainName constant, since the aggregator only needs
+// it as a metric label.
+const blockedChainName = "SCANNERS-BLOCK"
+
+const (
+	// whoisCacheTTL controls how long a resolved ASN/netname is trusted
+	// before being re-queried; allocations change, but not often enough to
+	// warrant looking them up every flush.
+	whoisCacheTTL = 24 * time.Hour
+
+	// whoisBatchSize caps how many IPs go into a single Cymru bulk whois
+	// session.
+	whoisBatchSize = 500
+)
+
+// Config holds the paths and timing Aggregator operates on.
+type Config struct {
+	IPv4LogPath    string
+	IPv6LogPath    string
+	OutputCSVPath  string
+	WhoisCachePath string
+	// GeoDBPath, if set, points at a local MaxMind GeoLite2-ASN database
+	// used instead of Team Cymru bulk whois for ASN/netname enrichment.
+	GeoDBPath string
+	Interval  time.Duration
+	// Metrics, if set, receives a blocked-packet increment for every parsed
+	// log line in addition to the CSV aggregate. Nil is fine - the "antiscan
+	// aggregate" command runs without it; "antiscan metrics" supplies one.
+	Metrics metrics.Provider
+	// Shipper, if set, receives a ShippedEvent for every parsed log line in
+	// addition to the CSV aggregate, for forwarding to a centralized SIEM.
+	// Nil is fine - set only when --ship-to is given.
+	Shipper RemoteShipper
+}
+
+// Aggregator replaces the AggregateLogsScriptTemplate bash pipeline: it
+// tails the per-family scanner logs via RotateSafeTailer instead of
+// re-reading whole files inside a shell "while read" loop, aggregates
+// counts and last-seen timestamps in memory, resolves ASN/netname through
+// CymruClient with a WhoisCache backing it, and atomically rewrites the CSV
+// sink on each tick. The log files are only read, never truncated, but the
+// counts live in memory until the next flush and every tailer starts at the
+// end of its file, so lines read but not yet flushed when the process dies
+// are not counted after a restart.
+type Aggregator struct {
+	logger   zerolog.Logger
+	cfg      Config
+	cache    *WhoisCache
+	resolver Resolver
+
+	counts map[string]*record // key: "<ipType>|<ip>"
+}
+
+// NewAggregator creates an Aggregator, opens its whois cache at
+// cfg.WhoisCachePath, and selects an enrichment Resolver — a local GeoLite2
+// database if cfg.GeoDBPath is set and opens cleanly, Team Cymru bulk whois
+// otherwise.
+func NewAggregator(logger zerolog.Logger, cfg Config) (*Aggregator, error) {
+	cache, err := NewWhoisCache(cfg.WhoisCachePath, whoisCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open whois cache: %w", err)
+	}
+
+	return &Aggregator{
+		logger:   logger,
+		cfg:      cfg,
+		cache:    cache,
+		resolver: detectResolver(logger, cfg.GeoDBPath, cache),
+		counts:   make(map[string]*record),
+	}, nil
+}
+
+// Close releases the whois cache's file handle and, if a Shipper was
+// configured, flushes and closes it too.
+func (a *Aggregator) Close() error {
+	if a.cfg.Shipper != nil {
+		if err := a.cfg.Shipper.Close(); err != nil {
+			a.logger.Warn().Err(err).Msg("Не удалось корректно закрыть shipper")
+		}
+	}
+	return a.cache.Close()
+}
+
+// Run tails both log files and flushes the aggregate CSV every
+// cfg.Interval until ctx is cancelled, performing one final flush on
+// shutdown so the most recent window isn't lost.
+func (a *Aggregator) Run(ctx context.Context) error {
+	v4, err := NewRotateSafeTailer(a.logger, a.cfg.IPv4LogPath)
+	if err != nil {
+		return fmt.Errorf("failed to tail %s: %w", a.cfg.IPv4LogPath, err)
+	}
+	defer v4.Close()
+
+	v6, err := NewRotateSafeTailer(a.logger, a.cfg.IPv6LogPath)
+	if err != nil {
+		return fmt.Errorf("failed to tail %s: %w", a.cfg.IPv6LogPath, err)
+	}
+	defer v6.Close()
+
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.drain(v4, "v4")
+			a.drain(v6, "v6")
+			if err := a.flush(); err != nil {
+				return fmt.Errorf("failed final flush: %w", err)
+			}
+			return ctx.Err()
+
+		case <-ticker.C:
+			a.drain(v4, "v4")
+			a.drain(v6, "v6")
+			if err := a.flush(); err != nil {
+				a.logger.Warn().Err(err).Msg("Не удалось сохранить агрегированный CSV")
+			}
+		}
+	}
+}
+
+// drain reads every line t has buffered since the last call and folds
+// matching SRC= addresses into a.counts. A read error (most likely the
+// file having been rotated out from under the tailer) triggers a Reopen
+// instead of propagating, since a single bad tick shouldn't stop the
+// daemon.
+func (a *Aggregator) drain(t *RotateSafeTailer, ipType string) {
+	lines, err := t.ReadNewLines()
+	if err != nil {
+		a.logger.Warn().Err(err).Str("type", ipType).Msg("Ошибка чтения лога, переоткрываем файл")
+		if reopenErr := t.Reopen(); reopenErr != nil {
+			a.logger.Warn().Err(reopenErr).Str("type", ipType).Msg("Не удалось переоткрыть лог после ротации")
+		}
+		return
+	}
+
+	now := time.Now()
+	for _, line := range lines {
+		ip, ok := parseSourceIP(line)
+		if !ok {
+			continue
+		}
+
+		key := ipType + "|" + ip
+		rec, exists := a.counts[key]
+		if !exists {
+			rec = &record{IPType: ipType, IP: ip, ASN: "UNKNOWN", Netname: "UNKNOWN"}
+			a.counts[key] = rec
+		}
+		rec.Count++
+		rec.LastSeen = now
+
+		if a.cfg.Metrics != nil {
+			a.cfg.Metrics.IncBlockedPacket(ipFamily(ipType), blockedChainName, rec.ASN)
+		}
+
+		if a.cfg.Shipper != nil {
+			proto, dstPort := parseProtoAndPort(line)
+			if err := a.cfg.Shipper.Ship(ShippedEvent{
+				Ts:      now,
+				SrcIP:   ip,
+				Family:  ipFamily(ipType),
+				ASN:     rec.ASN,
+				Netname: rec.Netname,
+				DstPort: dstPort,
+				Proto:   proto,
+			}); err != nil {
+				a.logger.Warn().Err(err).Str("src_ip", ip).Msg("Не удалось поставить событие в очередь отправки")
+			}
+		}
+	}
+}
+
+// ipFamily maps the aggregator's internal "v4"/"v6" tags onto the
+// "ipv4"/"ipv6" family label used elsewhere (see service.IPVersion).
+func ipFamily(ipType string) string {
+	if ipType == "v6" {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// flush resolves ASN/netname for any IP still unknown, then atomically
+// rewrites the output CSV with the current aggregate state.
+func (a *Aggregator) flush() error {
+	a.resolveUnknown()
+	if err := writeCSV(a.cfg.OutputCSVPath, a.counts); err != nil {
+		return err
+	}
+	if a.cfg.Metrics != nil {
+		a.cfg.Metrics.SetAggregateLastRun(float64(time.Now().Unix()))
+	}
+	return nil
+}
+
+// resolveUnknown resolves ASN/netname for every record still marked
+// UNKNOWN through a.resolver, in batches of whoisBatchSize.
+func (a *Aggregator) resolveUnknown() {
+	var pending []string
+	for _, rec := range a.counts {
+		if rec.ASN == "UNKNOWN" {
+			pending = append(pending, rec.IP)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	resolved := make(map[string]WhoisInfo, len(pending))
+	for start := 0; start < len(pending); start += whoisBatchSize {
+		end := start + whoisBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		batch, err := a.resolver.ResolveBatch(pending[start:end])
+		if err != nil {
+			a.logger.Warn().Err(err).Msg("Не удалось выполнить резолвинг ASN/netname")
+		}
+		for ip, info := range batch {
+			resolved[ip] = info
+		}
+	}
+
+	for _, rec := range a.counts {
+		if info, ok := resolved[rec.IP]; ok {
+			rec.ASN, rec.Netname = info.ASN, info.Netname
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// whoisBucket is the single bbolt bucket entries are stored in, keyed by IP.
+var whoisBucket = []byte("whois")
+
+// cachedWhois is what's actually persisted: the resolved info plus when it
+// was fetched, so TTL expiry can be checked on read without a separate
+// index.
+type cachedWhois struct {
+	WhoisInfo
+	FetchedAt time.Time
+}
+
+// WhoisCache persists resolved ASN/netname lookups across antiscan-aggregate
+// restarts, keyed by IP, so a crash or redeploy doesn't mean re-querying
+// Cymru for every IP still in the aggregation window. BoltDB (embedded,
+// single-file, no external service) is a natural fit for a process that
+// otherwise only touches the filesystem.
+type WhoisCache struct {
+	db  *bbolt.DB
+	ttl time.Duration
+}
+
+// NewWhoisCache opens (creating if necessary) the cache database at path.
+func NewWhoisCache(path string, ttl time.Duration) (*WhoisCache, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open whois cache %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(whoisBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize whois cache bucket: %w", err)
+	}
+
+	return &WhoisCache{db: db, ttl: ttl}, nil
+}
+
+// Close releases the underlying database file.
+func (c *WhoisCache) Close() error {
+	return c.db.Close()
+}
+
+// Get returns the cached WhoisInfo for ip, if present and not older than
+// the configured TTL.
+func (c *WhoisCache) Get(ip string) (WhoisInfo, bool) {
+	var info WhoisInfo
+	found := false
+
+	c.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(whoisBucket).Get([]byte(ip))
+		if data == nil {
+			return nil
+		}
+
+		var cached cachedWhois
+		if err := json.Unmarshal(data, &cached); err != nil {
+			return nil
+		}
+		if time.Since(cached.FetchedAt) > c.ttl {
+			return nil
+		}
+
+		info = cached.WhoisInfo
+		found = true
+		return nil
+	})
+
+	return info, found
+}
+
+// Set stores info for ip, timestamped with the current time for TTL
+// purposes.
+func (c *WhoisCache) Set(ip string, info WhoisInfo) error {
+	data, err := json.Marshal(cachedWhois{WhoisInfo: info, FetchedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode whois cache entry: %w", err)
+	}
+
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(whoisBucket).Put([]byte(ip), data)
+	})
+}
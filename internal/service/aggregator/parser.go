@@ -0,0 +1,38 @@
+package aggregator
+
+import "regexp"
+
+// srcIPPattern extracts the source address from an "ANTISCAN-v{4,6}: ...
+// SRC=... DST=... SPT=... DPT=..." log line, the format iptables' LOG
+// target produces for the rules RuleBuilder.LogPrefix installs.
+var srcIPPattern = regexp.MustCompile(`SRC=([0-9a-fA-F.:]+)`)
+
+// protoPattern and dptPattern extract the remaining fields ShippedEvent
+// needs from the same LOG line; both are optional since not every LOG
+// format includes them (e.g. ICMP has no DPT=).
+var (
+	protoPattern = regexp.MustCompile(`PROTO=(\S+)`)
+	dptPattern   = regexp.MustCompile(`DPT=(\d+)`)
+)
+
+// parseSourceIP extracts the SRC= address from a single log line, or
+// reports ok=false if the line doesn't look like a scanner-block LOG entry.
+func parseSourceIP(line string) (ip string, ok bool) {
+	m := srcIPPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// parseProtoAndPort extracts PROTO= and DPT= from a log line for
+// ShippedEvent; either may come back empty if the line doesn't carry it.
+func parseProtoAndPort(line string) (proto, dstPort string) {
+	if m := protoPattern.FindStringSubmatch(line); m != nil {
+		proto = m[1]
+	}
+	if m := dptPattern.FindStringSubmatch(line); m != nil {
+		dstPort = m[1]
+	}
+	return proto, dstPort
+}
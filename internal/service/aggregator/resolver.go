@@ -0,0 +1,174 @@
+package aggregator
+
+import (
+	"fmt"
+	"net"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/rs/zerolog"
+)
+
+// lruResolverSize bounds the in-memory cache every Resolver is wrapped in,
+// regardless of backend: a repeat-offender scanner hammering the same IP
+// within one aggregation window shouldn't hit Cymru or the mmdb reader more
+// than once.
+const lruResolverSize = 4096
+
+// Resolver resolves ASN/netname for a batch of IPs. Implementations return
+// whatever subset they could resolve rather than failing the whole batch, so
+// callers can still make progress on a partial result.
+type Resolver interface {
+	ResolveBatch(ips []string) (map[string]WhoisInfo, error)
+}
+
+// detectResolver prefers a local GeoLite2-ASN database when geoDBPath is
+// given and opens successfully — a single mmap'd file lookup instead of a
+// network round-trip per IP — falling back to Team Cymru bulk whois
+// (backed by whoisCache for persistence across restarts) otherwise. Either
+// way the result is wrapped in an in-memory LRU.
+func detectResolver(logger zerolog.Logger, geoDBPath string, whoisCache *WhoisCache) Resolver {
+	if geoDBPath != "" {
+		geo, err := newGeoliteResolver(logger, geoDBPath)
+		if err == nil {
+			logger.Info().Str("path", geoDBPath).Msg("Используется локальная база GeoLite2 для ASN/netname")
+			return newLRUResolver(geo)
+		}
+		logger.Warn().Err(err).Str("path", geoDBPath).Msg("Не удалось открыть базу GeoLite2, используется whois")
+	}
+
+	return newLRUResolver(newCymruResolver(logger, NewCymruClient(logger), whoisCache))
+}
+
+// cymruResolver resolves via Team Cymru bulk whois, consulting whoisCache
+// first so restarting the aggregator doesn't mean re-querying Cymru for
+// every IP it already knows about.
+type cymruResolver struct {
+	logger zerolog.Logger
+	client *CymruClient
+	cache  *WhoisCache
+}
+
+func newCymruResolver(logger zerolog.Logger, client *CymruClient, cache *WhoisCache) *cymruResolver {
+	return &cymruResolver{logger: logger, client: client, cache: cache}
+}
+
+func (r *cymruResolver) ResolveBatch(ips []string) (map[string]WhoisInfo, error) {
+	result := make(map[string]WhoisInfo, len(ips))
+
+	var pending []string
+	for _, ip := range ips {
+		if info, ok := r.cache.Get(ip); ok {
+			result[ip] = info
+			continue
+		}
+		pending = append(pending, ip)
+	}
+	if len(pending) == 0 {
+		return result, nil
+	}
+
+	resolved, err := r.client.LookupBulk(pending)
+	if err != nil {
+		return result, err
+	}
+
+	for ip, info := range resolved {
+		result[ip] = info
+		if err := r.cache.Set(ip, info); err != nil {
+			r.logger.Warn().Err(err).Str("ip", ip).Msg("Не удалось сохранить whois в кэш")
+		}
+	}
+
+	return result, nil
+}
+
+// geoliteASNRecord is the subset of a GeoLite2-ASN database record we care
+// about; field names/tags follow the official mmdb schema.
+type geoliteASNRecord struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoliteResolver resolves ASN/netname from a local MaxMind GeoLite2-ASN
+// database, avoiding any network call at lookup time.
+type geoliteResolver struct {
+	logger zerolog.Logger
+	db     *maxminddb.Reader
+}
+
+func newGeoliteResolver(logger zerolog.Logger, path string) (*geoliteResolver, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoLite2 database %s: %w", path, err)
+	}
+	return &geoliteResolver{logger: logger, db: db}, nil
+}
+
+func (r *geoliteResolver) ResolveBatch(ips []string) (map[string]WhoisInfo, error) {
+	result := make(map[string]WhoisInfo, len(ips))
+
+	for _, ip := range ips {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			continue
+		}
+
+		var rec geoliteASNRecord
+		if err := r.db.Lookup(addr, &rec); err != nil {
+			r.logger.Debug().Err(err).Str("ip", ip).Msg("Поиск в GeoLite2 не удался")
+			continue
+		}
+		if rec.AutonomousSystemNumber == 0 {
+			continue
+		}
+
+		result[ip] = WhoisInfo{
+			ASN:     fmt.Sprintf("AS%d", rec.AutonomousSystemNumber),
+			Netname: rec.AutonomousSystemOrganization,
+		}
+	}
+
+	return result, nil
+}
+
+// lruResolver wraps another Resolver with an in-memory LRU cache, so a
+// burst of repeat IPs within one process lifetime never reaches the
+// backend after the first lookup.
+type lruResolver struct {
+	inner Resolver
+	cache *lru.Cache[string, WhoisInfo]
+}
+
+func newLRUResolver(inner Resolver) *lruResolver {
+	cache, _ := lru.New[string, WhoisInfo](lruResolverSize)
+	return &lruResolver{inner: inner, cache: cache}
+}
+
+func (r *lruResolver) ResolveBatch(ips []string) (map[string]WhoisInfo, error) {
+	result := make(map[string]WhoisInfo, len(ips))
+
+	var misses []string
+	for _, ip := range ips {
+		if info, ok := r.cache.Get(ip); ok {
+			result[ip] = info
+			continue
+		}
+		misses = append(misses, ip)
+	}
+	if len(misses) == 0 {
+		return result, nil
+	}
+
+	resolved, err := r.inner.ResolveBatch(misses)
+	if err != nil {
+		return result, err
+	}
+
+	for ip, info := range resolved {
+		result[ip] = info
+		r.cache.Add(ip, info)
+	}
+
+	return result, nil
+}
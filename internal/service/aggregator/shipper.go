@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ShippedEvent is the structured record forwarded to a RemoteShipper for
+// every parsed scanner hit, in addition to the local CSV aggregate.
+type ShippedEvent struct {
+	Ts      time.Time `json:"ts"`
+	SrcIP   string    `json:"src_ip"`
+	Family  string    `json:"family"`
+	ASN     string    `json:"asn"`
+	Netname string    `json:"netname"`
+	DstPort string    `json:"dst_port"`
+	Proto   string    `json:"proto"`
+}
+
+// RemoteShipper forwards ShippedEvents to a centralized SIEM/log pipeline.
+// Implementations mirror NetfilterRunner/IpsetBackend/Resolver: Aggregator
+// depends only on this interface, so Kafka, syslog and HTTP backends are
+// interchangeable behind --ship-to.
+type RemoteShipper interface {
+	// Ship forwards a single event. Aggregator.drain only calls Ship on the
+	// ShippingQueue wrapper, which enqueues; the Kafka, syslog and HTTP
+	// backends' Ship runs on the queue's worker goroutine, so a slow or
+	// failing backend never blocks log tailing.
+	Ship(event ShippedEvent) error
+
+	// Close flushes any buffered state and releases the underlying
+	// connection/producer.
+	Close() error
+}
+
+const (
+	// shippingQueueSize bounds how many events can be buffered waiting for
+	// a slow RemoteShipper; once full, ShippingQueue.Ship drops the oldest
+	// queued event to make room rather than blocking the aggregator.
+	shippingQueueSize = 10000
+
+	// shipRetries is how many attempts ShippingQueue gives a single event
+	// before giving up on it.
+	shipRetries = 3
+
+	// shipRetryBackoff is the delay between retry attempts.
+	shipRetryBackoff = 500 * time.Millisecond
+)
+
+// ShippingQueue wraps a RemoteShipper with an in-process bounded queue and
+// a single worker goroutine, so Ship() is non-blocking from the caller's
+// perspective: a backpressured or down backend degrades to dropping the
+// oldest queued events instead of stalling the aggregator's drain loop.
+type ShippingQueue struct {
+	logger  zerolog.Logger
+	inner   RemoteShipper
+	events  chan ShippedEvent
+	done    chan struct{}
+	dropped int
+}
+
+// NewShippingQueue starts the worker goroutine and returns a RemoteShipper
+// that can be plugged straight into aggregator.Config.Shipper.
+func NewShippingQueue(logger zerolog.Logger, inner RemoteShipper) *ShippingQueue {
+	q := &ShippingQueue{
+		logger: logger,
+		inner:  inner,
+		events: make(chan ShippedEvent, shippingQueueSize),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Ship enqueues event, dropping the oldest queued event if the queue is
+// full rather than blocking the caller.
+func (q *ShippingQueue) Ship(event ShippedEvent) error {
+	select {
+	case q.events <- event:
+	default:
+		select {
+		case <-q.events:
+			q.dropped++
+		default:
+		}
+		select {
+		case q.events <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *ShippingQueue) run() {
+	for event := range q.events {
+		var err error
+		for attempt := 0; attempt < shipRetries; attempt++ {
+			if err = q.inner.Ship(event); err == nil {
+				break
+			}
+			time.Sleep(shipRetryBackoff)
+		}
+		if err != nil {
+			q.logger.Warn().Err(err).Str("src_ip", event.SrcIP).Msg("Не удалось отправить событие во внешний приёмник после повторных попыток")
+		}
+	}
+	close(q.done)
+}
+
+// Close stops accepting new events, waits for the queue to drain through
+// the worker goroutine, and closes the underlying RemoteShipper.
+func (q *ShippingQueue) Close() error {
+	close(q.events)
+	<-q.done
+	if q.dropped > 0 {
+		q.logger.Warn().Int("dropped", q.dropped).Msg("Очередь отправки событий переполнялась, часть событий была отброшена")
+	}
+	return q.inner.Close()
+}
+
+// NewShipperFromURL selects a RemoteShipper implementation by URL scheme:
+// kafka://broker1,broker2/topic, syslog+tls://host:port, or http(s)://...
+// for NDJSON batch POST. The returned shipper is already wrapped in a
+// ShippingQueue.
+func NewShipperFromURL(logger zerolog.Logger, rawURL string) (RemoteShipper, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --ship-to URL %q: %w", rawURL, err)
+	}
+
+	var inner RemoteShipper
+	switch {
+	case u.Scheme == "kafka":
+		inner, err = newKafkaShipper(logger, u)
+	case u.Scheme == "syslog+tls":
+		inner, err = newSyslogShipper(logger, u)
+	case u.Scheme == "http" || u.Scheme == "https":
+		inner = newHTTPShipper(logger, u)
+	default:
+		return nil, fmt.Errorf("unsupported --ship-to scheme %q (expected kafka/syslog+tls/http/https)", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewShippingQueue(logger, inner), nil
+}
+
+// trimLeadingSlash strips the leading "/" url.URL.Path leaves on values
+// like a Kafka topic or syslog path component.
+func trimLeadingSlash(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
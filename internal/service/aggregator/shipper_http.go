@@ -0,0 +1,122 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// httpShipperBatchSize flushes the buffer as soon as it reaches this
+	// many events, so a burst of scanner hits doesn't wait for the ticker.
+	httpShipperBatchSize = 200
+
+	// httpShipperFlushInterval is the maximum time an event waits in the
+	// buffer before being POSTed, so a quiet period still ships promptly.
+	httpShipperFlushInterval = 5 * time.Second
+)
+
+// httpShipper batches ShippedEvents and POSTs them as newline-delimited
+// JSON, the common ingestion format for HTTP-fronted log pipelines
+// (Loki, Logstash's http input, etc.).
+type httpShipper struct {
+	logger zerolog.Logger
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	buf     []ShippedEvent
+	done    chan struct{}
+	flushed chan struct{}
+}
+
+// newHTTPShipper builds a shipper that POSTs batches to u. Unlike the
+// Kafka/syslog shippers this can't fail at construction time - a bad
+// endpoint only shows up once Ship is actually called.
+func newHTTPShipper(logger zerolog.Logger, u *url.URL) *httpShipper {
+	s := &httpShipper{
+		logger:  logger,
+		url:     u.String(),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+	logger.Info().Str("url", s.url).Msg("Инициализирован HTTP shipper")
+	go s.flushLoop()
+	return s
+}
+
+func (s *httpShipper) flushLoop() {
+	ticker := time.NewTicker(httpShipperFlushInterval)
+	defer ticker.Stop()
+	defer close(s.flushed)
+
+	for {
+		select {
+		case <-s.done:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// Ship buffers event, flushing immediately once the buffer reaches
+// httpShipperBatchSize; otherwise it waits for flushLoop's ticker.
+func (s *httpShipper) Ship(event ShippedEvent) error {
+	s.mu.Lock()
+	s.buf = append(s.buf, event)
+	full := len(s.buf) >= httpShipperBatchSize
+	s.mu.Unlock()
+
+	if full {
+		s.flush()
+	}
+	return nil
+}
+
+func (s *httpShipper) flush() {
+	s.mu.Lock()
+	batch := s.buf
+	s.buf = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range batch {
+		if err := enc.Encode(event); err != nil {
+			s.logger.Warn().Err(err).Msg("Не удалось сериализовать событие для HTTP shipper")
+		}
+	}
+
+	resp, err := s.client.Post(s.url, "application/x-ndjson", &body)
+	if err != nil {
+		s.logger.Warn().Err(err).Int("events", len(batch)).Msg("Не удалось отправить пакет событий по HTTP")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		s.logger.Warn().Int("status", resp.StatusCode).Int("events", len(batch)).Msg("HTTP-приёмник вернул ошибку")
+	}
+}
+
+// Close stops the flush loop after a final flush of whatever remains
+// buffered.
+func (s *httpShipper) Close() error {
+	close(s.done)
+	<-s.flushed
+	return nil
+}
+
+var _ RemoteShipper = (*httpShipper)(nil)
@@ -0,0 +1,73 @@
+package aggregator
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaShipper forwards ShippedEvents to a Kafka topic via an async
+// kafka-go Writer. "Async" here means the library pipelines produce
+// requests internally; ShippingQueue is what actually keeps a slow broker
+// from blocking the aggregator.
+type kafkaShipper struct {
+	logger zerolog.Logger
+	writer *kafka.Writer
+}
+
+// newKafkaShipper builds a shipper for a "kafka://broker1:9092,broker2:9092/topic"
+// URL. TLS is enabled automatically when the URL carries "?tls=true".
+func newKafkaShipper(logger zerolog.Logger, u *url.URL) (*kafkaShipper, error) {
+	brokers := strings.Split(u.Host, ",")
+	if len(brokers) == 0 || brokers[0] == "" {
+		return nil, fmt.Errorf("kafka shipper URL %q is missing a broker list", u.String())
+	}
+
+	topic := trimLeadingSlash(u.Path)
+	if topic == "" {
+		return nil, fmt.Errorf("kafka shipper URL %q is missing a topic", u.String())
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.LeastBytes{},
+		RequiredAcks: kafka.RequireOne,
+		Async:        true,
+		BatchTimeout: 1 * time.Second,
+	}
+
+	if u.Query().Get("tls") == "true" {
+		writer.Transport = &kafka.Transport{TLS: &tls.Config{}} // crypto/tls defaults
+	}
+
+	logger.Info().Strs("brokers", brokers).Str("topic", topic).Msg("Инициализирован Kafka shipper")
+
+	return &kafkaShipper{logger: logger, writer: writer}, nil
+}
+
+func (s *kafkaShipper) Ship(event ShippedEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.SrcIP),
+		Value: payload,
+	})
+}
+
+func (s *kafkaShipper) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,98 @@
+package aggregator
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// syslogShipper forwards ShippedEvents as RFC5424 messages over a
+// persistent TCP+TLS connection. The standard library's log/syslog only
+// speaks the local Unix socket / UDP / plain-TCP RFC3164 dialect, so this
+// formats RFC5424 frames itself instead of depending on it.
+type syslogShipper struct {
+	logger   zerolog.Logger
+	addr     string
+	tlsConf  *tls.Config
+	conn     net.Conn
+	hostname string
+}
+
+// newSyslogShipper builds a shipper for a "syslog+tls://host:port" URL. The
+// connection is opened lazily on the first Ship call so a transient DNS or
+// network hiccup at startup doesn't prevent the aggregator from starting.
+func newSyslogShipper(logger zerolog.Logger, u *url.URL) (*syslogShipper, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("syslog+tls shipper URL %q is missing host:port", u.String())
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "antiscan"
+	}
+
+	logger.Info().Str("addr", u.Host).Msg("Инициализирован syslog+TLS shipper")
+
+	return &syslogShipper{
+		logger:   logger,
+		addr:     u.Host,
+		tlsConf:  &tls.Config{ServerName: u.Hostname()},
+		hostname: hostname,
+	}, nil
+}
+
+func (s *syslogShipper) ensureConn() error {
+	if s.conn != nil {
+		return nil
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", s.addr, s.tlsConf)
+	if err != nil {
+		return fmt.Errorf("failed to dial syslog+tls %s: %w", s.addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// syslogFacilityLocal0Notice is "local0.notice" (facility 16, severity 5),
+// one level below the warning severity (LogLevel "4") the SCANNERS-BLOCK
+// LOG rules use and the local rsyslog pipeline files under.
+const syslogFacilityLocal0Notice = 16*8 + 5
+
+// Ship formats event as a single RFC5424 frame (with octet-counting, the
+// framing TCP syslog receivers expect to avoid message-boundary ambiguity)
+// and writes it to the persistent connection. On a write error the
+// connection is closed and the error returned; the next call dials again.
+func (s *syslogShipper) Ship(event ShippedEvent) error {
+	msg := fmt.Sprintf("<%d>1 %s %s antiscan - - - src_ip=%s family=%s asn=%s netname=%q dst_port=%s proto=%s",
+		syslogFacilityLocal0Notice,
+		event.Ts.UTC().Format(time.RFC3339),
+		s.hostname,
+		event.SrcIP, event.Family, event.ASN, event.Netname, event.DstPort, event.Proto,
+	)
+	frame := fmt.Sprintf("%d %s", len(msg), msg)
+
+	if err := s.ensureConn(); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(s.conn, frame); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to write syslog frame: %w", err)
+	}
+
+	return nil
+}
+
+func (s *syslogShipper) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
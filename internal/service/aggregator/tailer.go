@@ -0,0 +1,102 @@
+package aggregator
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// RotateSafeTailer follows a log file the way "tail -F" does: it keeps
+// reading from its current offset as new lines are appended, and can be
+// told to Reopen() when logrotate renames/recreates the underlying file out
+// from under it. Unlike the old bash script, nothing is ever truncated by
+// the tailer itself — the log files are only ever read. A new tailer starts
+// at the end of the file (see NewRotateSafeTailer), so lines read before a
+// crash but not yet flushed to the aggregate CSV are not read again.
+type RotateSafeTailer struct {
+	logger  zerolog.Logger
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	partial strings.Builder
+}
+
+// NewRotateSafeTailer opens path and seeks to its current end, so only
+// lines appended after startup are read (the file's existing contents were
+// either already aggregated or belong to a previous antiscan run).
+func NewRotateSafeTailer(logger zerolog.Logger, path string) (*RotateSafeTailer, error) {
+	t := &RotateSafeTailer{logger: logger, path: path}
+	if err := t.reopen(true); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *RotateSafeTailer) reopen(seekToEnd bool) error {
+	if t.file != nil {
+		t.file.Close()
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+
+	if seekToEnd {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	t.file = f
+	t.reader = bufio.NewReader(f)
+	t.partial.Reset()
+	return nil
+}
+
+// Reopen re-opens the log file from its current contents' start. It is
+// called after logrotate replaces the inode antiscan was reading from
+// (rename-and-recreate or copytruncate).
+func (t *RotateSafeTailer) Reopen() error {
+	return t.reopen(false)
+}
+
+// ReadNewLines drains everything written to the file since the last call,
+// returning whole lines. A line with no trailing newline yet (the writer
+// is mid-write) is buffered and prefixed onto the next complete line
+// instead of being returned early.
+func (t *RotateSafeTailer) ReadNewLines() ([]string, error) {
+	var lines []string
+
+	for {
+		chunk, err := t.reader.ReadString('\n')
+		if len(chunk) > 0 {
+			if strings.HasSuffix(chunk, "\n") {
+				t.partial.WriteString(strings.TrimSuffix(chunk, "\n"))
+				lines = append(lines, t.partial.String())
+				t.partial.Reset()
+			} else {
+				t.partial.WriteString(chunk)
+			}
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return lines, nil
+			}
+			return lines, err
+		}
+	}
+}
+
+// Close releases the underlying file handle.
+func (t *RotateSafeTailer) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
@@ -0,0 +1,119 @@
+package aggregator
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// cymruWhoisAddr is Team Cymru's bulk whois endpoint. See
+// https://team-cymru.com/community-services/ip-asn-mapping/ for the
+// "begin/verbose/<ips>/end" bulk protocol this client speaks.
+const cymruWhoisAddr = "whois.cymru.com:43"
+
+// WhoisInfo is the ASN/netname pair resolved for a single IP.
+type WhoisInfo struct {
+	ASN     string
+	Netname string
+}
+
+// CymruClient resolves ASN/netname for many IPs in a single TCP session via
+// Team Cymru's bulk whois service, replacing the per-IP "whois -h
+// whois.ripe.net <ip>" exec the old bash script ran in a loop.
+type CymruClient struct {
+	logger  zerolog.Logger
+	addr    string
+	timeout time.Duration
+}
+
+// NewCymruClient creates a client talking to Team Cymru's bulk whois
+// service.
+func NewCymruClient(logger zerolog.Logger) *CymruClient {
+	return &CymruClient{
+		logger:  logger,
+		addr:    cymruWhoisAddr,
+		timeout: 10 * time.Second,
+	}
+}
+
+// LookupBulk resolves ASN/netname for every IP in ips over one TCP
+// connection. IPs the response doesn't cover (lookup failure on Cymru's
+// side) are simply absent from the returned map; callers treat that the
+// same as "still unknown" and retry on the next flush.
+func (c *CymruClient) LookupBulk(ips []string) (map[string]WhoisInfo, error) {
+	if len(ips) == 0 {
+		return nil, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	var req strings.Builder
+	req.WriteString("begin\nverbose\n")
+	for _, ip := range ips {
+		req.WriteString(ip)
+		req.WriteString("\n")
+	}
+	req.WriteString("end\n")
+
+	if _, err := conn.Write([]byte(req.String())); err != nil {
+		return nil, fmt.Errorf("failed to write whois request: %w", err)
+	}
+
+	result := make(map[string]WhoisInfo, len(ips))
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		info, ip, ok := parseCymruLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		result[ip] = info
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read whois response: %w", err)
+	}
+
+	return result, nil
+}
+
+// parseCymruLine parses one line of Cymru's verbose bulk response:
+//
+//	AS      | IP               | BGP Prefix          | CC | Registry | Allocated  | AS Name
+//	12345   | 1.2.3.4          | 1.2.0.0/16          | US | arin     | 2001-01-01 | EXAMPLE-NET, US
+//
+// taking the AS Name from the last column and returning ok=false for the
+// header row or any malformed line.
+func parseCymruLine(line string) (WhoisInfo, string, bool) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 3 {
+		return WhoisInfo{}, "", false
+	}
+
+	asn := strings.TrimSpace(fields[0])
+	ip := strings.TrimSpace(fields[1])
+	netname := strings.TrimSpace(fields[len(fields)-1])
+
+	if asn == "AS" || ip == "IP" || ip == "" {
+		// Header row.
+		return WhoisInfo{}, "", false
+	}
+
+	if asn == "" || asn == "NA" {
+		asn = "UNKNOWN"
+	} else if !strings.HasPrefix(asn, "AS") {
+		asn = "AS" + asn
+	}
+	if netname == "" {
+		netname = "UNKNOWN"
+	}
+
+	return WhoisInfo{ASN: asn, Netname: netname}, ip, true
+}
@@ -0,0 +1,57 @@
+package aggregator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// record is one aggregated row: a unique (ipType, ip) pair with its running
+// count and the ASN/netname once whois has resolved them.
+type record struct {
+	IPType   string
+	IP       string
+	ASN      string
+	Netname  string
+	Count    int
+	LastSeen time.Time
+}
+
+// writeCSV atomically rewrites path with the current records, sorted by
+// count descending (matching the old script's "sort -k5 -nr"), via
+// temp-file + rename so a reader never observes a half-written file.
+func writeCSV(path string, records map[string]*record) error {
+	sorted := make([]*record, 0, len(records))
+	for _, r := range records {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Count > sorted[j].Count })
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create temp CSV %s: %w", tmpPath, err)
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "IP_TYPE|IP_ADDRESS|ASN|NETNAME|COUNT|LAST_SEEN")
+	for _, r := range sorted {
+		fmt.Fprintf(w, "%s|%s|%s|%s|%d|%s\n",
+			r.IPType, r.IP, r.ASN, r.Netname, r.Count, r.LastSeen.Format(time.RFC3339))
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temp CSV %s: %w", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temp CSV %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 // CommandService provides centralized command execution
 type CommandService struct {
 	logger zerolog.Logger
+	dryRun bool
 }
 
 // NewCommandService creates a new command service
@@ -21,6 +22,15 @@ func NewCommandService(logger zerolog.Logger) *CommandService {
 	}
 }
 
+// SetDryRun toggles dry-run mode: Run, RunWithStdin and RunShell log the
+// command they would have executed and return nil instead of actually
+// running it. RunOutput/RunQuiet/RunOutputQuiet are left untouched since
+// callers use them for read-only queries (status checks, existence tests)
+// that dry-run has no reason to suppress.
+func (s *CommandService) SetDryRun(dryRun bool) {
+	s.dryRun = dryRun
+}
+
 // Run executes a command and returns error if it fails
 func (s *CommandService) Run(name string, args ...string) error {
 	s.logger.Debug().
@@ -28,6 +38,11 @@ func (s *CommandService) Run(name string, args ...string) error {
 		Strs("args", args).
 		Msg("Executing command")
 
+	if s.dryRun {
+		s.logger.Info().Str("command", name).Strs("args", args).Msg("[dry-run] Команда не выполнена")
+		return nil
+	}
+
 	cmd := exec.Command(name, args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -80,12 +95,50 @@ func (s *CommandService) RunOutputQuiet(name string, args ...string) (string, er
 	return string(output), err
 }
 
+// RunWithStdin executes a command, feeding stdin on its standard input, and
+// returns an error if it fails. Used for piping iptables-restore/ip6tables-restore
+// payloads instead of writing them to a temp file first.
+func (s *CommandService) RunWithStdin(stdin string, name string, args ...string) error {
+	s.logger.Debug().
+		Str("command", name).
+		Strs("args", args).
+		Int("stdin_bytes", len(stdin)).
+		Msg("Executing command with stdin")
+
+	if s.dryRun {
+		s.logger.Info().Str("command", name).Strs("args", args).Int("stdin_bytes", len(stdin)).Msg("[dry-run] Команда не выполнена")
+		return nil
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		s.logger.Error().
+			Err(err).
+			Str("command", name).
+			Strs("args", args).
+			Str("stderr", stderr.String()).
+			Msg("Command failed")
+		return fmt.Errorf("command '%s %s' failed: %w: %s", name, strings.Join(args, " "), err, stderr.String())
+	}
+
+	return nil
+}
+
 // RunShell executes a shell command (sh -c "command")
 func (s *CommandService) RunShell(command string) error {
 	s.logger.Debug().
 		Str("shell_command", command).
 		Msg("Executing shell command")
 
+	if s.dryRun {
+		s.logger.Info().Str("shell_command", command).Msg("[dry-run] Команда не выполнена")
+		return nil
+	}
+
 	return s.Run("sh", "-c", command)
 }
 
@@ -2,9 +2,16 @@ package service
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dotX12/traffic-guard/internal/domain"
@@ -12,45 +19,148 @@ import (
 	"github.com/rs/zerolog"
 )
 
+const (
+	downloaderCacheFileName = "downloader-cache.json"
+	downloaderWorkerCount   = 8
+	downloaderMaxRetries    = 3
+	downloaderRetryBaseWait = 500 * time.Millisecond
+)
+
+// cacheEntry holds the conditional-GET validators and last-seen body for a
+// single feed URL, so a re-run that gets back "304 Not Modified" can reuse
+// the body instead of re-downloading it.
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Body         string `json:"body"`
+}
+
+// URLStat reports what happened fetching a single feed URL, so callers can
+// log or expose per-feed bandwidth/cache-hit/error metrics instead of only
+// the aggregated subnet count.
+type URLStat struct {
+	URL          string
+	Bytes        int
+	CacheHit     bool
+	StatusCode   int
+	SubnetsAdded int
+	Err          error
+}
+
 // Downloader handles downloading subnet lists from URLs
 type Downloader struct {
 	logger     zerolog.Logger
 	httpClient *http.Client
+
+	cachePath string
+	cacheMu   sync.Mutex
+	cache     map[string]cacheEntry
 }
 
-// NewDownloader creates a new downloader service
-func NewDownloader(logger zerolog.Logger) *Downloader {
-	return &Downloader{
+// NewDownloader creates a new downloader service. stateDir is where the
+// per-URL ETag/Last-Modified/body cache is persisted between runs
+// (downloader-cache.json); pass "" to disable on-disk caching.
+func NewDownloader(logger zerolog.Logger, stateDir string) *Downloader {
+	d := &Downloader{
 		logger: logger,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		cache: make(map[string]cacheEntry),
+	}
+
+	if stateDir != "" {
+		d.cachePath = filepath.Join(stateDir, downloaderCacheFileName)
+		d.loadCache()
+	}
+
+	return d
+}
+
+// loadCache reads the on-disk cache, if any. A missing or corrupt cache is
+// treated as empty rather than a fatal error — worst case is a full
+// re-download instead of a conditional GET.
+func (d *Downloader) loadCache() {
+	data, err := os.ReadFile(d.cachePath)
+	if err != nil {
+		return
+	}
+
+	var cache map[string]cacheEntry
+	if err := json.Unmarshal(data, &cache); err != nil {
+		d.logger.Warn().Err(err).Str("path", d.cachePath).Msg("Не удалось прочитать кэш загрузчика, игнорируем")
+		return
+	}
+
+	d.cache = cache
+}
+
+// saveCache persists the current cache to disk, creating stateDir if
+// needed.
+func (d *Downloader) saveCache() {
+	if d.cachePath == "" {
+		return
+	}
+
+	d.cacheMu.Lock()
+	data, err := json.Marshal(d.cache)
+	d.cacheMu.Unlock()
+	if err != nil {
+		d.logger.Warn().Err(err).Msg("Не удалось сериализовать кэш загрузчика")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.cachePath), 0755); err != nil {
+		d.logger.Warn().Err(err).Msg("Не удалось создать директорию кэша загрузчика")
+		return
+	}
+
+	if err := os.WriteFile(d.cachePath, data, 0644); err != nil {
+		d.logger.Warn().Err(err).Str("path", d.cachePath).Msg("Не удалось сохранить кэш загрузчика")
 	}
 }
 
-// Download fetches subnets from multiple URLs and returns a NetworkList
-func (d *Downloader) Download(urls []string) (*domain.NetworkList, error) {
+// Download fetches subnets from multiple URLs concurrently (bounded by
+// downloaderWorkerCount) and returns a merged NetworkList along with
+// per-URL stats. Unlike an error from a single URL, errors here are
+// reported via URLStat.Err rather than failing the whole download.
+func (d *Downloader) Download(urls []string) (*domain.NetworkList, []URLStat, error) {
 	d.logger.Info().Int("url_count", len(urls)).Msg("Началась загрузка списков подсетей")
 
-	networks := domain.NewNetworkList()
-	seenSubnets := make(map[string]bool)
+	stats := make([]URLStat, len(urls))
+	lines := make([][]string, len(urls))
+
+	sem := make(chan struct{}, downloaderWorkerCount)
+	var wg sync.WaitGroup
 
 	for i, url := range urls {
-		d.logger.Info().
-			Int("index", i+1).
-			Int("total", len(urls)).
-			Str("url", url).
-			Msg("Загрузка списка подсетей")
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, url string) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-		subnets, err := d.downloadSingle(url)
-		if err != nil {
-			d.logger.Warn().
-				Err(err).
-				Str("url", url).
-				Msg("Не удалось загрузить из URL, пропуск")
-			continue
-		}
+			d.logger.Info().Str("url", url).Msg("Загрузка списка подсетей")
 
+			subnets, stat, err := d.downloadSingle(url)
+			stat.URL = url
+			if err != nil {
+				stat.Err = err
+				d.logger.Warn().Err(err).Str("url", url).Msg("Не удалось загрузить из URL, пропуск")
+			}
+
+			lines[i] = subnets
+			stats[i] = stat
+		}(i, url)
+	}
+
+	wg.Wait()
+	d.saveCache()
+
+	networks := domain.NewNetworkList()
+	seenSubnets := make(map[string]bool)
+
+	for i, subnets := range lines {
 		added := 0
 		for _, subnet := range subnets {
 			subnet = strings.TrimSpace(subnet)
@@ -58,7 +168,6 @@ func (d *Downloader) Download(urls []string) (*domain.NetworkList, error) {
 				continue
 			}
 
-			// Skip duplicates
 			if seenSubnets[subnet] {
 				continue
 			}
@@ -68,10 +177,12 @@ func (d *Downloader) Download(urls []string) (*domain.NetworkList, error) {
 			networks.Add(subnet, isIPv6)
 			added++
 		}
+		stats[i].SubnetsAdded = added
 
 		d.logger.Info().
 			Int("added", added).
-			Str("url", url).
+			Str("url", stats[i].URL).
+			Bool("cache_hit", stats[i].CacheHit).
 			Msg("Загрузка списка подсетей завершена")
 	}
 
@@ -81,36 +192,141 @@ func (d *Downloader) Download(urls []string) (*domain.NetworkList, error) {
 		Int("total", networks.TotalCount()).
 		Msg("Загрузка завершена")
 
-	return networks, nil
+	return networks, stats, nil
 }
 
-// downloadSingle downloads subnets from a single URL
-func (d *Downloader) downloadSingle(url string) ([]string, error) {
-	resp, err := d.httpClient.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("http request failed: %w", err)
+// downloadSingle fetches and parses a single URL, retrying transient
+// failures with exponential backoff.
+func (d *Downloader) downloadSingle(url string) ([]string, URLStat, error) {
+	var stat URLStat
+	var body []byte
+	var err error
+
+	wait := downloaderRetryBaseWait
+	for attempt := 0; attempt <= downloaderMaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		body, stat, err = d.fetch(url)
+		if err == nil || !isRetryableDownloadError(err) {
+			break
+		}
+
+		d.logger.Warn().
+			Err(err).
+			Str("url", url).
+			Int("attempt", attempt+1).
+			Msg("Повторная попытка загрузки после ошибки")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if err != nil {
+		return nil, stat, err
 	}
 
 	subnets := make([]string, 0)
-	scanner := bufio.NewScanner(resp.Body)
-
+	scanner := bufio.NewScanner(bytes.NewReader(body))
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line != "" {
 			subnets = append(subnets, line)
 		}
 	}
-
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, stat, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return subnets, stat, nil
+}
+
+// retryableDownloadError marks an error as eligible for downloadSingle's
+// exponential-backoff retry: 5xx responses and transient network failures,
+// but not a 4xx (the feed won't become reachable by retrying) or a parse
+// failure.
+type retryableDownloadError struct {
+	err error
+}
+
+func (e *retryableDownloadError) Error() string { return e.err.Error() }
+func (e *retryableDownloadError) Unwrap() error { return e.err }
+
+func isRetryableDownloadError(err error) bool {
+	_, ok := err.(*retryableDownloadError)
+	return ok
+}
+
+// fetch performs a single conditional-GET attempt against url, consulting
+// and updating the on-disk cache. A "304 Not Modified" response is treated
+// as a cache hit that reuses the previously stored body.
+func (d *Downloader) fetch(url string) ([]byte, URLStat, error) {
+	stat := URLStat{URL: url}
+
+	d.cacheMu.Lock()
+	cached, hasCache := d.cache[url]
+	d.cacheMu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, stat, fmt.Errorf("failed to build request: %w", err)
+	}
+	if hasCache {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, stat, &retryableDownloadError{fmt.Errorf("http request failed: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	stat.StatusCode = resp.StatusCode
+
+	if resp.StatusCode == http.StatusNotModified && hasCache {
+		stat.CacheHit = true
+		stat.Bytes = len(cached.Body)
+		return []byte(cached.Body), stat, nil
+	}
+
+	if resp.StatusCode >= 500 {
+		return nil, stat, &retryableDownloadError{fmt.Errorf("unexpected status code: %d", resp.StatusCode)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, stat, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	reader := io.Reader(resp.Body)
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, stat, fmt.Errorf("failed to decompress gzip response: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, stat, &retryableDownloadError{fmt.Errorf("failed to read response: %w", err)}
+	}
+	stat.Bytes = len(body)
+
+	if d.cachePath != "" {
+		d.cacheMu.Lock()
+		d.cache[url] = cacheEntry{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Body:         string(body),
+		}
+		d.cacheMu.Unlock()
 	}
 
-	return subnets, nil
+	return body, stat, nil
 }
 
 // isIPv6Subnet checks if a subnet is IPv6
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func ruleInstalled(fake *FakeExecutor, table, chain string, spec []string) bool {
+	want := strings.Join(spec, " ")
+	for _, r := range fake.Rules(table, chain) {
+		if r == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReconcilerServiceReapplyOnDrift(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+	r := NewReconcilerService(zerolog.Nop(), cmd, 0)
+
+	dropRule := NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()
+	r.Register(DesiredRule{Version: IPv4, Table: TableFilter, Chain: chainName, RuleSpec: dropRule})
+
+	// Nothing installed yet - a cheap-check pass should detect it's missing
+	// and re-apply it.
+	r.runOnce(false)
+	if !ruleInstalled(fake, string(TableFilter), chainName, dropRule) {
+		t.Fatalf("expected drop rule to be present in %s after runOnce", chainName)
+	}
+
+	// A second pass against the now-consistent state shouldn't error or
+	// duplicate the rule.
+	r.runOnce(false)
+	if len(fake.Rules(string(TableFilter), chainName)) != 1 {
+		t.Fatalf("expected exactly 1 rule in %s, got %d", chainName, len(fake.Rules(string(TableFilter), chainName)))
+	}
+
+	// Simulate external drift: something flushed the chain.
+	if err := fake.apply("4", []string{"-t", string(TableFilter), "-F", chainName}); err != nil {
+		t.Fatalf("simulated flush: %v", err)
+	}
+	if ruleInstalled(fake, string(TableFilter), chainName, dropRule) {
+		t.Fatalf("expected rule to be gone after simulated flush")
+	}
+
+	r.runOnce(false)
+	if !ruleInstalled(fake, string(TableFilter), chainName, dropRule) {
+		t.Fatalf("expected drop rule to be restored after drift was detected")
+	}
+}
+
+// TestReconcilerServiceFullCheckDoesNotDuplicateLogRule guards against a
+// regression where a full-check pass (diffAgainstSave) could never see an
+// already-installed LOG rule as present - because its --log-prefix value
+// ("ANTISCAN-v4: ") compared unequal to itself after a save/restore round
+// trip - and kept appending another copy of it on every tick.
+func TestReconcilerServiceFullCheckDoesNotDuplicateLogRule(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+	r := NewReconcilerService(zerolog.Nop(), cmd, 0)
+
+	logRule := NewRuleBuilder().
+		MatchSet(ipsetV4Name, "src").
+		Jump(TargetLog).
+		LogPrefix("ANTISCAN-v4: ").
+		LogLevel("4").
+		Build()
+	r.Register(DesiredRule{Version: IPv4, Table: TableFilter, Chain: chainName, RuleSpec: logRule})
+
+	r.runOnce(true)
+	if got := len(fake.Rules(string(TableFilter), chainName)); got != 1 {
+		t.Fatalf("expected 1 rule after first full-check runOnce, got %d: %v", got, fake.Rules(string(TableFilter), chainName))
+	}
+
+	// Repeated full checks against the now-converged state must not keep
+	// appending more copies of the LOG rule.
+	for i := 0; i < 3; i++ {
+		r.runOnce(true)
+	}
+	if got := len(fake.Rules(string(TableFilter), chainName)); got != 1 {
+		t.Fatalf("expected still 1 rule after repeated full checks, got %d: %v", got, fake.Rules(string(TableFilter), chainName))
+	}
+}
@@ -0,0 +1,16 @@
+package service
+
+// Executor is the command-execution surface IptablesCommandService and
+// IpsetCommandService depend on. CommandService is the production
+// implementation, shelling out via os/exec; FakeExecutor is an in-memory
+// stand-in used by tests so chain-setup logic can be exercised without root
+// privileges or a real kernel.
+type Executor interface {
+	Run(name string, args ...string) error
+	RunOutput(name string, args ...string) (string, error)
+	RunQuiet(name string, args ...string) error
+	RunOutputQuiet(name string, args ...string) (string, error)
+	RunWithStdin(stdin string, name string, args ...string) error
+	RunShell(command string) error
+	CommandExists(name string) bool
+}
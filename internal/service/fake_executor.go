@@ -0,0 +1,408 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Invocation records a single command FakeExecutor was asked to run, for
+// tests that want to assert on call order/arguments instead of (or in
+// addition to) inspecting the resulting table state.
+type Invocation struct {
+	Name  string
+	Args  []string
+	Stdin string
+}
+
+// FakeExecutor is an in-memory stand-in for Executor that understands just
+// enough of the iptables/ip6tables command-line grammar (-N/-X/-F/-A/-I/-D/-C/-L
+// and iptables-restore payloads) to exercise IptablesService/IptablesCommandService
+// chain-setup logic in tests, without root privileges or a real netfilter table.
+type FakeExecutor struct {
+	Invocations []Invocation
+
+	// tables maps "version/table/chain" (version being "4" or "6", inferred
+	// from the "iptables"/"ip6tables" command name - see cmdVersion) to its
+	// ordered list of rule specs, each rendered as the space-joined argument
+	// string that would follow "-A <chain>" for that rule. Keying by version
+	// keeps IPv4 and IPv6 state independent, matching real iptables/ip6tables.
+	tables map[string][]string
+
+	// policies maps the same "version/table/chain" key to the policy a "-P"
+	// exec or a restore declaration line set for a built-in chain. A key
+	// absent here and rendered via renderSave/ParseRestoreOutput falls back
+	// to "ACCEPT" for built-in chains, "-" otherwise - matching a host where
+	// no operator has hardened the default policy yet.
+	policies map[string]string
+}
+
+// NewFakeExecutor creates an empty FakeExecutor with no chains.
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		tables:   make(map[string][]string),
+		policies: make(map[string]string),
+	}
+}
+
+// cmdVersion infers the IP version ("4" or "6") a command name like
+// "iptables"/"ip6tables-save"/"ip6tables-restore" targets.
+func cmdVersion(name string) string {
+	if strings.HasPrefix(name, "ip6tables") {
+		return "6"
+	}
+	return "4"
+}
+
+func chainKey(version, table, chain string) string {
+	return version + "/" + table + "/" + chain
+}
+
+// Rules returns the current IPv4 rule specs of chain in table, in order.
+// Use RulesForVersion to inspect the IPv6 side.
+func (f *FakeExecutor) Rules(table, chain string) []string {
+	return f.tables[chainKey("4", table, chain)]
+}
+
+// RulesForVersion returns the current rule specs of chain in table for the
+// given IP version, in order.
+func (f *FakeExecutor) RulesForVersion(version IPVersion, table, chain string) []string {
+	v := "4"
+	if version == IPv6 {
+		v = "6"
+	}
+	return f.tables[chainKey(v, table, chain)]
+}
+
+// ChainExists reports whether chain has been created in table's IPv4 state.
+func (f *FakeExecutor) ChainExists(table, chain string) bool {
+	_, ok := f.tables[chainKey("4", table, chain)]
+	return ok
+}
+
+func (f *FakeExecutor) record(stdin, name string, args ...string) {
+	f.Invocations = append(f.Invocations, Invocation{Name: name, Args: args, Stdin: stdin})
+}
+
+// Run executes a single iptables/ip6tables-style invocation against the
+// in-memory table state.
+func (f *FakeExecutor) Run(name string, args ...string) error {
+	f.record("", name, args...)
+	return f.apply(cmdVersion(name), args)
+}
+
+// RunOutput behaves like Run but additionally renders a "-L"-style listing
+// when asked for one, or an "iptables-save"-style dump of a table's state
+// when name ends in "-save" (CurrentRuleSet's path).
+func (f *FakeExecutor) RunOutput(name string, args ...string) (string, error) {
+	f.record("", name, args...)
+	version := cmdVersion(name)
+	if strings.HasSuffix(name, "-save") {
+		return f.renderSave(version, args), nil
+	}
+	if out, handled := f.list(version, args); handled {
+		return out, nil
+	}
+	return "", f.apply(version, args)
+}
+
+// RunQuiet is identical to Run; FakeExecutor has no concept of verbosity.
+func (f *FakeExecutor) RunQuiet(name string, args ...string) error {
+	return f.Run(name, args...)
+}
+
+// RunOutputQuiet is identical to RunOutput.
+func (f *FakeExecutor) RunOutputQuiet(name string, args ...string) (string, error) {
+	return f.RunOutput(name, args...)
+}
+
+// RunWithStdin applies an iptables-restore style payload to the in-memory
+// table state via ParseRestoreOutput.
+func (f *FakeExecutor) RunWithStdin(stdin string, name string, args ...string) error {
+	f.record(stdin, name, args...)
+	return f.applyRestore(cmdVersion(name), stdin)
+}
+
+// RunShell records the command and reports success without running it or
+// touching the in-memory tables, so the shell redirections that
+// IptablesCommandService.Save/Restore use are not modelled.
+func (f *FakeExecutor) RunShell(command string) error {
+	f.record("", "sh", "-c", command)
+	return nil
+}
+
+// CommandExists always reports true: FakeExecutor stands in for a host
+// where iptables/ip6tables/ipset are installed.
+func (f *FakeExecutor) CommandExists(name string) bool {
+	return true
+}
+
+// apply interprets a single iptables-style argument vector against the
+// in-memory table, mutating it for -N/-X/-F/-A/-I/-D and returning an error
+// for -C/-L misses (mirroring the real binaries' exit codes).
+func (f *FakeExecutor) apply(version string, args []string) error {
+	table := "filter"
+	i := 0
+	if i < len(args) && args[i] == "-t" {
+		table = args[i+1]
+		i += 2
+	}
+	if i >= len(args) {
+		return fmt.Errorf("fake iptables: missing operation")
+	}
+
+	op := args[i]
+	rest := args[i+1:]
+
+	switch op {
+	case "-N":
+		chain := rest[0]
+		key := chainKey(version, table, chain)
+		if _, ok := f.tables[key]; ok {
+			return fmt.Errorf("fake iptables: chain %s already exists", chain)
+		}
+		f.tables[key] = []string{}
+		return nil
+	case "-X":
+		chain := rest[0]
+		delete(f.tables, chainKey(version, table, chain))
+		return nil
+	case "-F":
+		if len(rest) == 0 {
+			for key := range f.tables {
+				f.tables[key] = []string{}
+			}
+			return nil
+		}
+		key := chainKey(version, table, rest[0])
+		if _, ok := f.tables[key]; !ok {
+			return fmt.Errorf("fake iptables: chain %s does not exist", rest[0])
+		}
+		f.tables[key] = []string{}
+		return nil
+	case "-A":
+		chain := rest[0]
+		key := chainKey(version, table, chain)
+		f.tables[key] = append(f.tables[key], renderRuleSpec(rest[1:]))
+		return nil
+	case "-I":
+		chain := rest[0]
+		rest = rest[1:]
+		position := 1
+		if len(rest) > 0 {
+			if n, err := strconv.Atoi(rest[0]); err == nil {
+				position = n
+				rest = rest[1:]
+			}
+		}
+		key := chainKey(version, table, chain)
+		rules := f.tables[key]
+		idx := position - 1
+		if idx < 0 || idx > len(rules) {
+			idx = len(rules)
+		}
+		spec := renderRuleSpec(rest)
+		rules = append(rules, "")
+		copy(rules[idx+1:], rules[idx:])
+		rules[idx] = spec
+		f.tables[key] = rules
+		return nil
+	case "-D":
+		chain := rest[0]
+		rest = rest[1:]
+		key := chainKey(version, table, chain)
+		rules := f.tables[key]
+		if len(rest) == 1 {
+			if n, err := strconv.Atoi(rest[0]); err == nil {
+				idx := n - 1
+				if idx < 0 || idx >= len(rules) {
+					return fmt.Errorf("fake iptables: no rule %d in chain %s", n, chain)
+				}
+				f.tables[key] = append(rules[:idx], rules[idx+1:]...)
+				return nil
+			}
+		}
+		spec := renderRuleSpec(rest)
+		for idx, r := range rules {
+			if r == spec {
+				f.tables[key] = append(rules[:idx], rules[idx+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("fake iptables: rule not found in chain %s", chain)
+	case "-C":
+		chain := rest[0]
+		spec := renderRuleSpec(rest[1:])
+		for _, r := range f.tables[chainKey(version, table, chain)] {
+			if r == spec {
+				return nil
+			}
+		}
+		return fmt.Errorf("fake iptables: rule not found in chain %s", chain)
+	case "-P":
+		chain := rest[0]
+		policy := rest[1]
+		key := chainKey(version, table, chain)
+		if _, ok := f.tables[key]; !ok {
+			f.tables[key] = []string{}
+		}
+		f.policies[key] = policy
+		return nil
+	case "-L":
+		// Existence/listing checks are handled by list(); reaching here via
+		// apply() means RunQuiet was used to probe existence only.
+		chain := ""
+		if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+			chain = rest[0]
+		}
+		if chain == "" {
+			return nil
+		}
+		if _, ok := f.tables[chainKey(version, table, chain)]; !ok {
+			return fmt.Errorf("fake iptables: chain %s does not exist", chain)
+		}
+		return nil
+	default:
+		return fmt.Errorf("fake iptables: unsupported operation %q", op)
+	}
+}
+
+// list renders "-L" output for RuleLineNumber/ListChain callers. Returns
+// handled=false for anything that isn't a listing so apply() can take over.
+func (f *FakeExecutor) list(version string, args []string) (string, bool) {
+	table := "filter"
+	i := 0
+	if i < len(args) && args[i] == "-t" {
+		table = args[i+1]
+		i += 2
+	}
+	if i >= len(args) || args[i] != "-L" {
+		return "", false
+	}
+	rest := args[i+1:]
+	chain := ""
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		chain = rest[0]
+	}
+	if chain == "" {
+		return "", false
+	}
+	rules, ok := f.tables[chainKey(version, table, chain)]
+	if !ok {
+		return "", false
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Chain %s (0 references)\n", chain)
+	for idx, r := range rules {
+		fields := strings.Fields(r)
+		target := ""
+		if len(fields) >= 2 && fields[0] == "-j" {
+			target = fields[1]
+		}
+		fmt.Fprintf(&b, "%d %s %s\n", idx+1, target, r)
+	}
+	return b.String(), true
+}
+
+// renderSave renders the in-memory state of the "-t <table>" named in args
+// (or "filter" if none was given, matching real iptables' default) in the
+// same "*table"/":chain - [0:0]"/"-A chain <spec>"/"COMMIT" grammar real
+// iptables-save and ParseRestoreOutput use, so CurrentRuleSet/Apply can be
+// exercised end-to-end against FakeExecutor instead of only the raw rule
+// lists FakeExecutor.Rules exposes directly.
+func (f *FakeExecutor) renderSave(version string, args []string) string {
+	table := "filter"
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-t" && i+1 < len(args) {
+			table = args[i+1]
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s\n", table)
+	prefix := version + "/" + table + "/"
+	for key, rules := range f.tables {
+		chain, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, ":%s %s [0:0]\n", chain, f.chainPolicy(key, chain))
+		for _, rule := range rules {
+			fmt.Fprintf(&b, "-A %s %s\n", chain, rule)
+		}
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+// chainPolicy returns the policy to render for chain's declaration line:
+// whatever a prior "-P" exec or restore declaration set under key, or the
+// real binaries' default otherwise (ACCEPT for a built-in chain that's
+// never been hardened, "-" for a user-defined chain, which can't have a
+// policy at all).
+func (f *FakeExecutor) chainPolicy(key, chain string) string {
+	if policy, ok := f.policies[key]; ok {
+		return policy
+	}
+	if isBuiltinChain(chain) {
+		return "ACCEPT"
+	}
+	return "-"
+}
+
+// applyRestore replays an iptables-restore payload (as produced by
+// RestoreBatch.Render) against the in-memory table state for the given IP
+// version.
+func (f *FakeExecutor) applyRestore(version, payload string) error {
+	table := "filter"
+	for _, line := range strings.Split(payload, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case line == "COMMIT":
+			continue
+		case strings.HasPrefix(line, "*"):
+			table = strings.TrimPrefix(line, "*")
+		case strings.HasPrefix(line, ":"):
+			fields := strings.Fields(line)
+			chain := strings.TrimPrefix(fields[0], ":")
+			key := chainKey(version, table, chain)
+			if _, ok := f.tables[key]; !ok {
+				f.tables[key] = []string{}
+			}
+			// Real iptables-restore sets a chain's policy from its
+			// declaration line unless the line uses the "-" (no policy)
+			// placeholder - it does this unconditionally, even under
+			// --noflush and even for a chain that already exists, which is
+			// exactly the behavior EnsureBuiltinChain's callers must work
+			// around by reading the current policy first.
+			if len(fields) > 1 && fields[1] != "-" {
+				f.policies[key] = fields[1]
+			}
+		default:
+			args, err := splitRestoreArgs(line)
+			if err != nil {
+				return fmt.Errorf("fake iptables-restore: %w", err)
+			}
+			if err := f.apply(version, append([]string{"-t", table}, args...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitRestoreArgs splits a single iptables-restore rule line ("-A CHAIN
+// -j DROP") into an argument vector, using the same quote-aware tokenizer
+// ParseRestoreOutput uses - RestoreBatch quotes whitespace-containing values
+// like --log-prefix "ANTISCAN-v4: ", and plain whitespace splitting would
+// tear those in two.
+func splitRestoreArgs(line string) ([]string, error) {
+	fields := splitRuleSpec(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty rule line")
+	}
+	return fields, nil
+}
@@ -6,102 +6,252 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
-// InstallerService handles package installation
-type InstallerService struct {
-	logger zerolog.Logger
+// distroFamily identifies a family of Linux distributions that share a
+// package manager and package-naming scheme.
+type distroFamily string
+
+const (
+	distroDebian  distroFamily = "debian"
+	distroRedHat  distroFamily = "redhat"
+	distroArch    distroFamily = "arch"
+	distroSUSE    distroFamily = "suse"
+	distroAlpine  distroFamily = "alpine"
+	distroUnknown distroFamily = "unknown"
+)
+
+// osRelease holds the /etc/os-release fields distroFamily detection needs.
+type osRelease struct {
+	id     string
+	idLike []string
 }
 
-// NewInstallerService creates a new installer service
-func NewInstallerService(logger zerolog.Logger) *InstallerService {
-	return &InstallerService{
-		logger: logger,
+// readOSRelease parses /etc/os-release (falling back to /usr/lib/os-release),
+// the identification mechanism systemd recommends distros be detected by,
+// instead of probing for marker files like /etc/debian_version.
+func readOSRelease() osRelease {
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		data, err = os.ReadFile("/usr/lib/os-release")
+		if err != nil {
+			return osRelease{}
+		}
+	}
+
+	var rel osRelease
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch key {
+		case "ID":
+			rel.id = value
+		case "ID_LIKE":
+			rel.idLike = strings.Fields(value)
+		}
 	}
+	return rel
 }
 
-// EnsureDependencies checks and installs required packages
-func (s *InstallerService) EnsureDependencies() error {
-	s.logger.Info().Msg("Проверка зависимостей")
+// family maps the os-release ID/ID_LIKE fields to the distroFamily whose
+// package manager and package names apply. ID is checked first since
+// ID_LIKE is only a hint toward the closest relative.
+func (o osRelease) family() distroFamily {
+	ids := append([]string{o.id}, o.idLike...)
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu", "linuxmint", "raspbian", "pop", "elementary", "kali":
+			return distroDebian
+		case "rhel", "fedora", "centos", "rocky", "almalinux", "ol", "amzn":
+			return distroRedHat
+		case "arch", "manjaro", "endeavouros":
+			return distroArch
+		case "suse", "opensuse", "opensuse-leap", "opensuse-tumbleweed", "sles":
+			return distroSUSE
+		case "alpine":
+			return distroAlpine
+		}
+	}
+	return distroUnknown
+}
 
-	distro := getDistroType()
-	s.logger.Debug().Str("distro", distro).Msg("Detected distribution")
+// PackageManager abstracts the distro-specific tool used to install
+// antiscan's runtime dependencies, so InstallerService doesn't need a
+// switch statement per distro at every call site.
+type PackageManager interface {
+	// Name identifies the package manager, for logging only.
+	Name() string
+	// Install installs the named logical package (e.g. "ip6tables"),
+	// translating it to this distro's own package name first.
+	Install(pkg string) error
+}
 
-	// Check iptables
-	if !s.commandExists("iptables") {
-		s.logger.Info().Msg("Установка iptables")
-		if err := s.installPackage("iptables", distro); err != nil {
-			return fmt.Errorf("failed to install iptables: %w", err)
-		}
-		s.logger.Info().Msg("iptables установлен")
-	} else {
-		s.logger.Debug().Msg("iptables уже установлен")
+// aptPackageManager installs packages via apt-get on Debian-family distros.
+type aptPackageManager struct {
+	run commandRunner
+}
+
+// aptPackageNames maps logical dependency names to Debian package names
+// where they differ from the logical name.
+var aptPackageNames = map[string]string{
+	"ip6tables": "iptables", // ip6tables ships inside the iptables package
+}
+
+func (m *aptPackageManager) Name() string { return "apt" }
+
+func (m *aptPackageManager) Install(pkg string) error {
+	name := aptPackageNames[pkg]
+	if name == "" {
+		name = pkg
 	}
 
-	// Check ip6tables
-	if !s.commandExists("ip6tables") {
-		s.logger.Info().Msg("Установка ip6tables")
-		if err := s.installPackage("ip6tables", distro); err != nil {
-			return fmt.Errorf("failed to install ip6tables: %w", err)
+	os.Setenv("DEBIAN_FRONTEND", "noninteractive")
+	if err := m.run("apt-get", "install", "-y", name); err != nil {
+		// The package list may just be stale - refresh it once and retry
+		// before giving up.
+		if updateErr := m.run("apt-get", "update"); updateErr != nil {
+			return fmt.Errorf("failed to update apt-get: %w", updateErr)
 		}
-		s.logger.Info().Msg("ip6tables установлен")
-	} else {
-		s.logger.Debug().Msg("ip6tables уже установлен")
+		return m.run("apt-get", "install", "-y", name)
 	}
+	return nil
+}
 
-	// Check ipset
-	if !s.commandExists("ipset") {
-		s.logger.Info().Msg("Установка ipset")
+// rpmPackageManager installs packages via dnf or yum on RHEL-family distros.
+// Fedora and RHEL 8+ derivatives ship dnf; older RHEL/CentOS 7 only has yum -
+// detectPackageManager picks whichever is actually on PATH.
+type rpmPackageManager struct {
+	run commandRunner
+	bin string
+}
 
-		// Try without update first
-		err := s.installPackage("ipset", distro)
-		if err != nil {
-			// If failed and it's debian, try with update
-			if distro == "debian" {
-				s.logger.Warn().Msg("Попытка обновления apt-get")
-				if err := s.runCommand("apt-get", "update"); err != nil {
-					return fmt.Errorf("failed to update apt-get: %w", err)
-				}
-				// Try again after update
-				if err := s.installPackage("ipset", distro); err != nil {
-					return fmt.Errorf("failed to install ipset: %w", err)
-				}
-			} else {
-				return fmt.Errorf("failed to install ipset: %w", err)
-			}
-		}
-		s.logger.Info().Msg("ipset установлен")
-	} else {
-		s.logger.Debug().Msg("ipset уже установлен")
+var rpmPackageNames = map[string]string{
+	"ip6tables": "iptables", // both binaries ship in the iptables package
+}
+
+func (m *rpmPackageManager) Name() string { return m.bin }
+
+func (m *rpmPackageManager) Install(pkg string) error {
+	name := rpmPackageNames[pkg]
+	if name == "" {
+		name = pkg
 	}
+	return m.run(m.bin, "install", "-y", name)
+}
 
-	s.logger.Info().Msg("Все зависимости удовлетворены")
-	return nil
+// pacmanPackageManager installs packages via pacman on Arch-family distros.
+type pacmanPackageManager struct {
+	run commandRunner
 }
 
-// EnsureNetfilterPersistent checks and installs netfilter-persistent if needed
-func (s *InstallerService) EnsureNetfilterPersistent() error {
-	s.logger.Info().Msg("Проверка системы сохранения правил")
+var pacmanPackageNames = map[string]string{
+	"ip6tables": "iptables", // both binaries ship in the iptables package
+}
+
+func (m *pacmanPackageManager) Name() string { return "pacman" }
+
+func (m *pacmanPackageManager) Install(pkg string) error {
+	name := pacmanPackageNames[pkg]
+	if name == "" {
+		name = pkg
+	}
+	return m.run("pacman", "-S", "--noconfirm", "--needed", name)
+}
+
+// zypperPackageManager installs packages via zypper on SUSE-family distros.
+type zypperPackageManager struct {
+	run commandRunner
+}
+
+var zypperPackageNames = map[string]string{
+	"ip6tables": "iptables", // both binaries ship in the iptables package
+}
+
+func (m *zypperPackageManager) Name() string { return "zypper" }
 
-	distro := getDistroType()
+func (m *zypperPackageManager) Install(pkg string) error {
+	name := zypperPackageNames[pkg]
+	if name == "" {
+		name = pkg
+	}
+	return m.run("zypper", "--non-interactive", "install", name)
+}
+
+// apkPackageManager installs packages via apk on Alpine.
+type apkPackageManager struct {
+	run commandRunner
+}
+
+// apkPackageNames maps logical dependency names to Alpine package names.
+// Unlike the other families, Alpine packages "ip6tables" separately from
+// "iptables" under its own literal name, so no translation is needed here.
+var apkPackageNames = map[string]string{}
+
+func (m *apkPackageManager) Name() string { return "apk" }
+
+func (m *apkPackageManager) Install(pkg string) error {
+	name := apkPackageNames[pkg]
+	if name == "" {
+		name = pkg
+	}
+	return m.run("apk", "add", name)
+}
 
-	// Only for Debian-based systems
-	if distro != "debian" {
-		s.logger.Debug().Msg("netfilter-persistent доступен только для Debian-based систем")
+// commandRunner matches InstallerService.runCommand's signature, so package
+// managers and persistence mechanisms can run commands without depending on
+// InstallerService directly.
+type commandRunner func(name string, args ...string) error
+
+// detectPackageManager returns the PackageManager for family, or nil if
+// family is unrecognized and dependency installation must be done manually.
+func detectPackageManager(run commandRunner, family distroFamily) PackageManager {
+	switch family {
+	case distroDebian:
+		return &aptPackageManager{run: run}
+	case distroRedHat:
+		bin := "yum"
+		if commandExists("dnf") {
+			bin = "dnf"
+		}
+		return &rpmPackageManager{run: run, bin: bin}
+	case distroArch:
+		return &pacmanPackageManager{run: run}
+	case distroSUSE:
+		return &zypperPackageManager{run: run}
+	case distroAlpine:
+		return &apkPackageManager{run: run}
+	default:
 		return nil
 	}
+}
 
-	// Check if UFW is installed
+// PersistenceMechanism abstracts how a distro family persists iptables rules
+// across reboots, so EnsureNetfilterPersistent isn't Debian-only.
+type PersistenceMechanism interface {
+	// Ensure installs/enables whatever this distro needs so rules survive a
+	// reboot. Returning nil with nothing done is valid - e.g. when another
+	// tool (UFW) already owns persistence.
+	Ensure(s *InstallerService) error
+}
+
+// debianPersistence defers to UFW if present, otherwise installs
+// netfilter-persistent/iptables-persistent.
+type debianPersistence struct{}
+
+func (debianPersistence) Ensure(s *InstallerService) error {
 	if s.commandExists("ufw") {
 		s.logger.Info().Msg("UFW обнаружен - netfilter-persistent не требуется")
 		s.logger.Debug().Msg("UFW будет управлять сохранением правил")
 		return nil
 	}
 
-	// Check if netfilter-persistent is already installed
 	if s.commandExists("netfilter-persistent") {
 		s.logger.Debug().Msg("netfilter-persistent уже установлен")
 		return nil
@@ -109,15 +259,11 @@ func (s *InstallerService) EnsureNetfilterPersistent() error {
 
 	s.logger.Info().Msg("UFW не найден - установка netfilter-persistent и iptables-persistent")
 
-	// Update package list first
 	if err := s.runCommand("apt-get", "update"); err != nil {
 		s.logger.Warn().Err(err).Msg("Не удалось обновить apt-get")
 	}
 
-	// Set non-interactive mode to avoid prompts
 	os.Setenv("DEBIAN_FRONTEND", "noninteractive")
-
-	// Install both packages
 	if err := s.runCommand("apt-get", "install", "-y", "netfilter-persistent", "iptables-persistent"); err != nil {
 		return fmt.Errorf("failed to install netfilter-persistent: %w", err)
 	}
@@ -126,26 +272,120 @@ func (s *InstallerService) EnsureNetfilterPersistent() error {
 	return nil
 }
 
-// installPackage installs a package based on distro type
-func (s *InstallerService) installPackage(pkg, distro string) error {
-	s.logger.Debug().Str("package", pkg).Str("distro", distro).Msg("Installing package")
+// systemdPersistence enables a fixed list of systemd units, optionally
+// installing a package first - e.g. RHEL-family's rules aren't persisted by
+// the base iptables package, so "iptables-services" has to be installed
+// before "iptables"/"ip6tables" units exist to enable.
+type systemdPersistence struct {
+	installPkg string
+	units      []string
+}
+
+func (p systemdPersistence) Ensure(s *InstallerService) error {
+	if p.installPkg != "" {
+		if err := s.installPackage(p.installPkg); err != nil {
+			return fmt.Errorf("failed to install %s: %w", p.installPkg, err)
+		}
+	}
+
+	for _, unit := range p.units {
+		if err := s.runCommand("systemctl", "enable", unit); err != nil {
+			s.logger.Warn().Err(err).Str("unit", unit).Msg("Не удалось включить systemd unit для сохранения правил")
+		}
+	}
+	return nil
+}
+
+// noopPersistence is used for distro families chunk3-5 doesn't yet automate
+// persistence for; it logs the gap instead of silently doing nothing.
+type noopPersistence struct {
+	family distroFamily
+}
+
+func (p noopPersistence) Ensure(s *InstallerService) error {
+	s.logger.Debug().Str("distro_family", string(p.family)).
+		Msg("Автоматическое сохранение правил недоступно для этого дистрибутива, настройте вручную")
+	return nil
+}
 
-	switch distro {
-	case "debian":
-		return s.runCommand("apt-get", "install", "-y", pkg)
-	case "redhat":
-		return s.runCommand("yum", "install", "-y", pkg)
+// detectPersistence returns the PersistenceMechanism appropriate for family.
+func detectPersistence(family distroFamily) PersistenceMechanism {
+	switch family {
+	case distroDebian:
+		return debianPersistence{}
+	case distroRedHat:
+		return systemdPersistence{installPkg: "iptables-services", units: []string{"iptables", "ip6tables"}}
+	case distroArch:
+		return systemdPersistence{units: []string{"iptables", "ip6tables"}}
 	default:
-		return fmt.Errorf("unsupported distribution. Please install %s manually", pkg)
+		return noopPersistence{family: family}
+	}
+}
+
+// InstallerService handles package installation
+type InstallerService struct {
+	logger zerolog.Logger
+	family distroFamily
+	pm     PackageManager
+}
+
+// NewInstallerService creates a new installer service
+func NewInstallerService(logger zerolog.Logger) *InstallerService {
+	s := &InstallerService{logger: logger}
+	s.family = readOSRelease().family()
+	s.pm = detectPackageManager(s.runCommand, s.family)
+	return s
+}
+
+// EnsureDependencies checks and installs required packages
+func (s *InstallerService) EnsureDependencies() error {
+	s.logger.Info().Msg("Проверка зависимостей")
+	s.logger.Debug().Str("distro_family", string(s.family)).Msg("Detected distribution")
+
+	for _, pkg := range []string{"iptables", "ip6tables", "ipset"} {
+		if s.commandExists(pkg) {
+			s.logger.Debug().Str("package", pkg).Msg("уже установлен")
+			continue
+		}
+
+		s.logger.Info().Str("package", pkg).Msg("Установка пакета")
+		if err := s.installPackage(pkg); err != nil {
+			return fmt.Errorf("failed to install %s: %w", pkg, err)
+		}
+		s.logger.Info().Str("package", pkg).Msg("пакет установлен")
+	}
+
+	s.logger.Info().Msg("Все зависимости удовлетворены")
+	return nil
+}
+
+// EnsureNetfilterPersistent checks and installs whatever this distro needs
+// so iptables rules survive a reboot.
+func (s *InstallerService) EnsureNetfilterPersistent() error {
+	s.logger.Info().Msg("Проверка системы сохранения правил")
+	return detectPersistence(s.family).Ensure(s)
+}
+
+// installPackage installs pkg through the detected PackageManager.
+func (s *InstallerService) installPackage(pkg string) error {
+	if s.pm == nil {
+		return fmt.Errorf("unsupported distribution %q, please install %s manually", s.family, pkg)
 	}
+	s.logger.Debug().Str("package", pkg).Str("package_manager", s.pm.Name()).Msg("Installing package")
+	return s.pm.Install(pkg)
 }
 
 // commandExists checks if a command is available in PATH
-func (s *InstallerService) commandExists(cmd string) bool {
+func commandExists(cmd string) bool {
 	_, err := exec.LookPath(cmd)
 	return err == nil
 }
 
+// commandExists checks if a command is available in PATH
+func (s *InstallerService) commandExists(cmd string) bool {
+	return commandExists(cmd)
+}
+
 // runCommand executes a command with timeout
 func (s *InstallerService) runCommand(name string, args ...string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
@@ -171,17 +411,6 @@ func (s *InstallerService) runCommand(name string, args ...string) error {
 	return nil
 }
 
-// getDistroType detects the Linux distribution type
-func getDistroType() string {
-	if _, err := os.Stat("/etc/debian_version"); err == nil {
-		return "debian"
-	}
-	if _, err := os.Stat("/etc/redhat-release"); err == nil {
-		return "redhat"
-	}
-	return "unknown"
-}
-
 // CheckRootPrivileges verifies the program is running as root
 func (s *InstallerService) CheckRootPrivileges() error {
 	if os.Geteuid() != 0 {
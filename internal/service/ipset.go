@@ -1,7 +1,9 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 
 	"github.com/dotX12/traffic-guard/internal/domain"
@@ -16,17 +18,49 @@ const (
 
 // IpsetService handles ipset operations
 type IpsetService struct {
-	logger   zerolog.Logger
-	cmdSvc   *CommandService
-	ipsetCmd *IpsetCommandService
+	logger    zerolog.Logger
+	cmdSvc    *CommandService
+	ipsetCmd  *IpsetCommandService
+	backend   IpsetBackend
+	netfilter NetfilterRunner
 }
 
-// NewIpsetService creates a new ipset service
-func NewIpsetService(logger zerolog.Logger, cmdSvc *CommandService) *IpsetService {
+// NewIpsetService creates a new ipset service. preferNetlink selects the
+// netlink-based IpsetBackend (see detectIpsetBackend) for Setup/Fill's bulk
+// set operations when available, falling back to shelling out through
+// ipsetCmd otherwise; Save/Restore/CreateRestoreService always go through
+// the ipset binary since they have no netlink equivalent.
+func NewIpsetService(logger zerolog.Logger, cmdSvc *CommandService, preferNetlink bool) *IpsetService {
+	ipsetCmd := NewIpsetCommandService(logger, cmdSvc)
 	return &IpsetService{
 		logger:   logger,
 		cmdSvc:   cmdSvc,
-		ipsetCmd: NewIpsetCommandService(logger, cmdSvc),
+		ipsetCmd: ipsetCmd,
+		backend:  detectIpsetBackend(logger, ipsetCmd, preferNetlink),
+	}
+}
+
+// SetNetfilterRunner wires the host's active NetfilterRunner into the ipset
+// service so that Fill/AtomicReload/ApplyDiff also push set membership into
+// it via NetfilterRunner.SyncSet. Only the nftables backend does real work
+// here - its DROP rule matches its own native inet set, a separate kernel
+// object from the ipset this service otherwise maintains, so without this it
+// would block nothing. Safe to leave unset (e.g. in tests): syncNftSet
+// no-ops when netfilter is nil.
+func (s *IpsetService) SetNetfilterRunner(netfilter NetfilterRunner) {
+	s.netfilter = netfilter
+}
+
+// syncNftSet pushes subnets into the active NetfilterRunner's copy of version's
+// member set, logging (not failing) on error - a stale nftables set falls
+// back to "blocks nothing new" rather than aborting an otherwise-successful
+// ipset update.
+func (s *IpsetService) syncNftSet(version IPVersion, subnets []string) {
+	if s.netfilter == nil {
+		return
+	}
+	if err := s.netfilter.SyncSet(version, subnets); err != nil {
+		s.logger.Warn().Err(err).Str("version", string(version)).Msg("Не удалось синхронизировать набор nftables")
 	}
 }
 
@@ -53,15 +87,21 @@ func (s *IpsetService) setupSet(name, family string) error {
 	s.logger.Debug().Str("set", name).Msg("Проверка набора ipset")
 
 	// Check if set exists
-	if s.ipsetCmd.Exists(name) {
+	if s.backend.Exists(name) {
 		s.logger.Info().Str("set", name).Msg("Очищаем существующий набор")
-		if err := s.ipsetCmd.Flush(name); err != nil {
+		if err := s.backend.Flush(name); err != nil {
 			return fmt.Errorf("failed to flush set %s: %w", name, err)
 		}
 		s.logger.Info().Str("set", name).Msg("Set flushed")
 	} else {
 		s.logger.Info().Str("set", name).Str("family", family).Msg("Creating set")
-		if err := s.ipsetCmd.CreateHashNet(name, Family(family), 1024, 65536); err != nil {
+		if err := s.backend.Create(CreateSetOptions{
+			Name:     name,
+			Type:     SetTypeHashNet,
+			Family:   Family(family),
+			HashSize: 1024,
+			MaxElem:  65536,
+		}); err != nil {
 			return fmt.Errorf("failed to create set %s: %w", name, err)
 		}
 		s.logger.Info().Str("set", name).Msg("Set created")
@@ -77,11 +117,18 @@ func (s *IpsetService) Fill(networks *domain.NetworkList) error {
 		Int("ipv6_count", networks.IPv6Count()).
 		Msg("Заполнение ipset списков")
 
-	// Fill IPv4
+	// Fill IPv4. NotifyStatus is best-effort: outside a Type=notify unit it
+	// silently fails and is ignored, same as every other sd_notify call.
+	_ = NotifyStatus("Loading 0/%d subnets (IPv4)", networks.IPv4Count())
 	addedV4, errorsV4 := s.fillSet(ipsetV4Name, networks.IPv4Subnets, "IPv4")
+	s.syncNftSet(IPv4, networks.IPv4Subnets)
+	_ = NotifyStatus("Loaded %d/%d subnets (IPv4)", addedV4, networks.IPv4Count())
 
 	// Fill IPv6
+	_ = NotifyStatus("Loading 0/%d subnets (IPv6)", networks.IPv6Count())
 	addedV6, errorsV6 := s.fillSet(ipsetV6Name, networks.IPv6Subnets, "IPv6")
+	s.syncNftSet(IPv6, networks.IPv6Subnets)
+	_ = NotifyStatus("Loaded %d/%d subnets (IPv6)", addedV6, networks.IPv6Count())
 
 	s.logger.Info().
 		Int("ipv4_added", addedV4).
@@ -93,32 +140,200 @@ func (s *IpsetService) Fill(networks *domain.NetworkList) error {
 	return nil
 }
 
-// fillSet adds subnets to a specific ipset set
+// fillSet adds subnets to a specific ipset set, batching through the
+// IpsetBackend (the netlink backend folds thousands of entries into one or
+// two netlink messages; the shell backend still execs ipset per entry).
 func (s *IpsetService) fillSet(setName string, subnets []string, label string) (added, errors int) {
 	total := len(subnets)
 	s.logger.Info().Int("total", total).Str("type", label).Msg("Добавление подсетей в ipset")
 
-	for i, subnet := range subnets {
-		if err := s.ipsetCmd.Add(setName, subnet); err == nil {
-			added++
-			if (i+1)%100 == 0 {
-				s.logger.Debug().
-					Int("progress", i+1).
-					Int("total", total).
-					Str("type", label).
-					Msg("Progress")
-			}
-		} else {
-			errors++
-			s.logger.Warn().
-				Err(err).
-				Str("subnet", subnet).
-				Str("set", setName).
-				Msg("Failed to add subnet")
+	added, err := s.backend.AddBatch(setName, subnets)
+	if err != nil {
+		s.logger.Warn().
+			Err(err).
+			Str("set", setName).
+			Msg("Failed to add subnets")
+		errors = total - added
+		return added, errors
+	}
+
+	s.logger.Debug().
+		Int("added", added).
+		Int("total", total).
+		Str("type", label).
+		Msg("Progress")
+
+	return added, 0
+}
+
+// AtomicReload repopulates the live SCANNERS-BLOCK-V4/V6 sets without ever
+// leaving them empty: it builds shadow sets (suffixed "-new"), fills them
+// completely, then swaps each shadow with its live counterpart via
+// IpsetCommandService.Swap and destroys the old contents. Because the shadow
+// set is never referenced until the swap, this also sidesteps the RHEL/EL9
+// "bad performance restoring large sets which are in use" penalty that
+// restore -exist hits when writing into a set iptables is actively matching
+// against.
+func (s *IpsetService) AtomicReload(networks *domain.NetworkList) error {
+	s.logger.Info().
+		Int("ipv4_count", networks.IPv4Count()).
+		Int("ipv6_count", networks.IPv6Count()).
+		Msg("Атомарная перезагрузка наборов ipset")
+
+	if err := s.atomicReloadSet(ipsetV4Name, networks.IPv4Subnets, FamilyIPv4, "IPv4"); err != nil {
+		return fmt.Errorf("failed to reload IPv4 set: %w", err)
+	}
+	s.syncNftSet(IPv4, networks.IPv4Subnets)
+
+	if err := s.atomicReloadSet(ipsetV6Name, networks.IPv6Subnets, FamilyIPv6, "IPv6"); err != nil {
+		return fmt.Errorf("failed to reload IPv6 set: %w", err)
+	}
+	s.syncNftSet(IPv6, networks.IPv6Subnets)
+
+	s.logger.Info().Msg("Наборы ipset атомарно перезагружены")
+	return nil
+}
+
+// atomicReloadSet builds and populates a shadow set for liveName, then
+// swaps it in. If liveName doesn't exist yet (first run), the shadow set is
+// simply renamed into place since there's nothing to swap with.
+func (s *IpsetService) atomicReloadSet(liveName string, subnets []string, family Family, label string) error {
+	shadowName := liveName + "-new"
+
+	// A previous reload may have been interrupted between creating the
+	// shadow set and swapping it in; start clean.
+	if s.backend.Exists(shadowName) {
+		s.logger.Warn().Str("set", shadowName).Msg("Найден незавершённый теневой набор, удаляем")
+		if err := s.backend.Destroy(shadowName); err != nil {
+			return fmt.Errorf("failed to destroy stale shadow set %s: %w", shadowName, err)
+		}
+	}
+
+	if err := s.backend.Create(CreateSetOptions{
+		Name:     shadowName,
+		Type:     SetTypeHashNet,
+		Family:   family,
+		HashSize: 1024,
+		MaxElem:  65536,
+	}); err != nil {
+		return fmt.Errorf("failed to create shadow set %s: %w", shadowName, err)
+	}
+
+	added, err := s.backend.AddBatch(shadowName, subnets)
+	if err != nil {
+		s.backend.Destroy(shadowName)
+		return fmt.Errorf("failed to populate shadow set %s: %w", shadowName, err)
+	}
+	s.logger.Info().
+		Int("added", added).
+		Int("total", len(subnets)).
+		Str("type", label).
+		Str("set", shadowName).
+		Msg("Теневой набор заполнен")
+
+	if !s.backend.Exists(liveName) {
+		s.logger.Info().Str("set", liveName).Msg("Живой набор отсутствует, переименовываем теневой")
+		return s.ipsetCmd.Rename(shadowName, liveName)
+	}
+
+	if err := s.backend.Swap(liveName, shadowName); err != nil {
+		return fmt.Errorf("failed to swap %s/%s: %w", liveName, shadowName, err)
+	}
+
+	if err := s.backend.Destroy(shadowName); err != nil {
+		s.logger.Warn().Err(err).Str("set", shadowName).Msg("Не удалось удалить старый набор после swap")
+	}
+
+	return nil
+}
+
+// ApplyDiff incrementally reconciles the live SCANNERS-BLOCK-V4/V6 sets with
+// networks: subnets present in networks but missing from a set are added,
+// subnets present in a set but no longer in networks are removed. Unlike
+// AtomicReload this never swaps or recreates a set, so it never interrupts
+// a connection an existing entry is currently matching against — the right
+// tool for a SIGHUP-triggered blocklist refresh on a live gateway.
+func (s *IpsetService) ApplyDiff(networks *domain.NetworkList) (added, removed int, err error) {
+	addedV4, removedV4, err := s.applyDiffSet(ipsetV4Name, networks.IPv4Subnets)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reconcile IPv4 set: %w", err)
+	}
+	s.syncNftSet(IPv4, networks.IPv4Subnets)
+
+	addedV6, removedV6, err := s.applyDiffSet(ipsetV6Name, networks.IPv6Subnets)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reconcile IPv6 set: %w", err)
+	}
+	s.syncNftSet(IPv6, networks.IPv6Subnets)
+
+	return addedV4 + addedV6, removedV4 + removedV6, nil
+}
+
+// applyDiffSet adds entries in desired that setName is missing, and removes
+// entries setName has that are no longer in desired.
+func (s *IpsetService) applyDiffSet(setName string, desired []string) (added, removed int, err error) {
+	current, err := s.backend.Members(setName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list members of %s: %w", setName, err)
+	}
+
+	// Members lists a /32 or /128 entry as a bare address, so both sides are
+	// compared in that form.
+	canonical := func(entry string) string {
+		if p, err := netip.ParsePrefix(entry); err == nil && p.IsSingleIP() {
+			return p.Addr().String()
+		}
+		return entry
+	}
+
+	currentSet := make(map[string]struct{}, len(current))
+	for _, entry := range current {
+		currentSet[canonical(entry)] = struct{}{}
+	}
+	desiredSet := make(map[string]struct{}, len(desired))
+	for _, entry := range desired {
+		desiredSet[canonical(entry)] = struct{}{}
+	}
+
+	var toAdd []string
+	for _, entry := range desired {
+		if _, ok := currentSet[canonical(entry)]; !ok {
+			toAdd = append(toAdd, entry)
+		}
+	}
+
+	added, err = s.backend.AddBatch(setName, toAdd)
+	if err != nil {
+		return added, 0, fmt.Errorf("failed to add new entries to %s: %w", setName, err)
+	}
+
+	for _, entry := range current {
+		if _, ok := desiredSet[canonical(entry)]; ok {
+			continue
 		}
+		if err := s.backend.Delete(setName, entry); err != nil && !errors.Is(err, ErrSetEntryMissing) {
+			s.logger.Warn().Err(err).Str("set", setName).Str("entry", entry).Msg("Не удалось удалить устаревшую запись")
+			continue
+		}
+		removed++
 	}
 
-	return added, errors
+	return added, removed, nil
+}
+
+// Counts returns the current number of entries in the IPv4 and IPv6 sets,
+// used by "antiscan status" to report live set sizes without requiring the
+// caller to know about IpsetBackend.
+func (s *IpsetService) Counts() (v4, v6 int, err error) {
+	v4Members, err := s.backend.Members(ipsetV4Name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list members of %s: %w", ipsetV4Name, err)
+	}
+	v6Members, err := s.backend.Members(ipsetV6Name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list members of %s: %w", ipsetV6Name, err)
+	}
+	return len(v4Members), len(v6Members), nil
 }
 
 // Save saves ipset configuration to file
@@ -145,6 +360,36 @@ func (s *IpsetService) Restore(path string) error {
 	return nil
 }
 
+// Teardown destroys the SCANNERS-BLOCK-V4/V6 sets and removes the restore
+// systemd service Setup/CreateRestoreService installed. Best-effort: a set
+// or service that's already gone isn't an error, since uninstall must work
+// even after a partial install.
+func (s *IpsetService) Teardown() error {
+	s.logger.Info().Msg("Удаление наборов ipset")
+
+	for _, name := range []string{ipsetV4Name, ipsetV6Name} {
+		if !s.backend.Exists(name) {
+			continue
+		}
+		if err := s.backend.Destroy(name); err != nil {
+			s.logger.Warn().Err(err).Str("set", name).Msg("Не удалось удалить набор")
+		}
+	}
+
+	if err := s.cmdSvc.Run("systemctl", "disable", "antiscan-ipset-restore.service"); err != nil {
+		s.logger.Debug().Err(err).Msg("antiscan-ipset-restore уже отключен")
+	}
+	if err := os.Remove(IpsetRestoreServicePath); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn().Err(err).Str("path", IpsetRestoreServicePath).Msg("Не удалось удалить файл")
+	}
+	if err := s.cmdSvc.DaemonReload(); err != nil {
+		s.logger.Warn().Err(err).Msg("Не удалось перезагрузить демон systemd")
+	}
+
+	s.logger.Info().Msg("Наборы ipset удалены")
+	return nil
+}
+
 // CreateRestoreService creates systemd service to restore ipset on boot
 func (s *IpsetService) CreateRestoreService() error {
 	s.logger.Info().Msg("Создание systemd сервиса для загрузки конфигурации ipset")
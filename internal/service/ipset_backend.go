@@ -0,0 +1,181 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Typed ipset errors. The netlink backend maps kernel error codes onto these
+// so callers can branch with errors.Is. The shell backend returns the ipset
+// command's own error instead (IpsetCommandService.Test still matches
+// "is NOT in set" in it); of its messages only ipset's already-added one is
+// recognised, by isIpsetEntryExistsError.
+var (
+	// ErrSetEntryExists marks an entry that is already a member of the set.
+	// ipset itself treats this as a non-fatal warning ("Element cannot be
+	// added to the set: it's already added"), and AddBatch skips such
+	// entries.
+	ErrSetEntryExists = errors.New("ipset: entry already exists in set")
+
+	// ErrSetEntryMissing is returned when deleting or testing an entry that
+	// isn't a member of the set.
+	ErrSetEntryMissing = errors.New("ipset: entry not found in set")
+
+	// ErrSetFull is returned when a set has reached its maxelem limit.
+	ErrSetFull = errors.New("ipset: set is full")
+
+	// ErrWrongFamily is returned when an entry's address family doesn't
+	// match the set's configured family (e.g. adding an IPv6 CIDR to an
+	// "inet" hash:net set).
+	ErrWrongFamily = errors.New("ipset: address family does not match set")
+)
+
+// IpsetBackend abstracts how TrafficGuard talks to the kernel's ipset
+// subsystem, mirroring the NetfilterRunner split between classic
+// iptables/ip6tables and nftables: IpsetCommandService drives the ipset
+// binary over exec, netlinkIpsetBackend talks NFNETLINK_SUBSYS_IPSET
+// directly for bulk loads. IpsetService picks one via detectIpsetBackend.
+type IpsetBackend interface {
+	// Create makes sure a set matching opts exists.
+	Create(opts CreateSetOptions) error
+
+	// Destroy removes a set entirely.
+	Destroy(name string) error
+
+	// Flush removes all entries from a set without destroying it.
+	Flush(name string) error
+
+	// AddBatch adds entries to setName, batching as many as the backend
+	// allows in a single round-trip. It returns the number of entries
+	// actually added; entries already present are skipped and counted
+	// neither as added nor as an error.
+	AddBatch(setName string, entries []string) (added int, err error)
+
+	// Delete removes a single entry from a set.
+	Delete(setName, entry string) error
+
+	// Exists reports whether a set has been created.
+	Exists(name string) bool
+
+	// Swap atomically exchanges the contents of two sets of the same type.
+	Swap(setName1, setName2 string) error
+
+	// Members lists every entry currently in a set, as CIDR or bare-IP
+	// strings; a full-length prefix (/32, /128) passed to AddBatch comes
+	// back as a bare address. Used to diff a live set against a freshly
+	// downloaded blocklist.
+	Members(name string) ([]string, error)
+}
+
+// shellIpsetBackend implements IpsetBackend on top of IpsetCommandService,
+// i.e. by shelling out to the ipset binary. It is the universal fallback:
+// it works on any Linux host with ipset installed, regardless of kernel
+// netlink family support or process capabilities.
+type shellIpsetBackend struct {
+	cmd *IpsetCommandService
+}
+
+func newShellIpsetBackend(cmd *IpsetCommandService) *shellIpsetBackend {
+	return &shellIpsetBackend{cmd: cmd}
+}
+
+func (b *shellIpsetBackend) Create(opts CreateSetOptions) error {
+	return b.cmd.Create(opts)
+}
+
+func (b *shellIpsetBackend) Destroy(name string) error {
+	return b.cmd.Destroy(name)
+}
+
+func (b *shellIpsetBackend) Flush(name string) error {
+	return b.cmd.Flush(name)
+}
+
+func (b *shellIpsetBackend) AddBatch(setName string, entries []string) (int, error) {
+	added := 0
+	for _, entry := range entries {
+		if err := b.cmd.Add(setName, entry); err != nil {
+			if isIpsetEntryExistsError(err) {
+				continue
+			}
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+func (b *shellIpsetBackend) Delete(setName, entry string) error {
+	return b.cmd.Delete(setName, entry)
+}
+
+func (b *shellIpsetBackend) Exists(name string) bool {
+	return b.cmd.Exists(name)
+}
+
+func (b *shellIpsetBackend) Swap(setName1, setName2 string) error {
+	return b.cmd.Swap(setName1, setName2)
+}
+
+func (b *shellIpsetBackend) Members(name string) ([]string, error) {
+	output, err := b.cmd.List(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseIpsetMembers(output), nil
+}
+
+// parseIpsetMembers extracts entry strings from "ipset list <name>" output,
+// i.e. every non-empty line after the "Members:" marker, taking only the
+// first field so a timeout/comment suffix doesn't end up part of the entry.
+func parseIpsetMembers(output string) []string {
+	var members []string
+	inMembers := false
+
+	for _, line := range strings.Split(output, "\n") {
+		if !inMembers {
+			if strings.TrimSpace(line) == "Members:" {
+				inMembers = true
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		members = append(members, fields[0])
+	}
+
+	return members
+}
+
+// isIpsetEntryExistsError reports whether err came from ipset refusing to
+// add an entry that is already a member of the set, the one "error" that's
+// actually fine to ignore: ErrSetEntryExists from the netlink backend, or
+// the ipset binary's "it's already added" message from the shell backend.
+func isIpsetEntryExistsError(err error) bool {
+	return err != nil && (errors.Is(err, ErrSetEntryExists) ||
+		strings.Contains(err.Error(), "it's already added"))
+}
+
+// detectIpsetBackend picks the preferred IpsetBackend for the running host:
+// the netlink backend when the kernel's ipset netlink family is reachable,
+// falling back to the shell backend (non-Linux, missing CAP_NET_ADMIN, or
+// a kernel built without NFNETLINK_SUBSYS_IPSET).
+func detectIpsetBackend(logger zerolog.Logger, cmd *IpsetCommandService, preferNetlink bool) IpsetBackend {
+	if !preferNetlink {
+		return newShellIpsetBackend(cmd)
+	}
+
+	backend, err := newNetlinkIpsetBackend(logger)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Netlink ipset backend недоступен, используется ipset через exec")
+		return newShellIpsetBackend(cmd)
+	}
+
+	logger.Info().Msg("Используется netlink ipset backend")
+	return backend
+}
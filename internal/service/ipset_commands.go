@@ -10,11 +10,11 @@ import (
 // IpsetCommandService provides high-level ipset operations
 type IpsetCommandService struct {
 	logger zerolog.Logger
-	cmdSvc *CommandService
+	cmdSvc Executor
 }
 
 // NewIpsetCommandService creates a new ipset command service
-func NewIpsetCommandService(logger zerolog.Logger, cmdSvc *CommandService) *IpsetCommandService {
+func NewIpsetCommandService(logger zerolog.Logger, cmdSvc Executor) *IpsetCommandService {
 	return &IpsetCommandService{
 		logger: logger,
 		cmdSvc: cmdSvc,
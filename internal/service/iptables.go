@@ -18,18 +18,30 @@ type IptablesService struct {
 	enableLogging bool
 	cmdSvc        *CommandService
 	iptablesCmd   *IptablesCommandService
+	runner        NetfilterRunner
 }
 
-// NewIptablesService creates a new iptables service
+// NewIptablesService creates a new iptables service. The underlying
+// NetfilterRunner backend (iptables/ip6tables or nftables) is auto-detected
+// based on what the host actually has installed, see detectNetfilterBackend.
 func NewIptablesService(logger zerolog.Logger, cmdSvc *CommandService, enableLogging bool) *IptablesService {
 	return &IptablesService{
 		logger:        logger,
 		enableLogging: enableLogging,
 		cmdSvc:        cmdSvc,
 		iptablesCmd:   NewIptablesCommandService(logger, cmdSvc),
+		runner:        detectNetfilterBackend(logger, cmdSvc),
 	}
 }
 
+// Runner exposes the auto-detected NetfilterRunner backing this service, so
+// callers that need to keep a backend's own copy of set membership in sync
+// (see NetfilterRunner.SyncSet) can reach it without duplicating detection -
+// IpsetService.SetNetfilterRunner is the intended consumer.
+func (s *IptablesService) Runner() NetfilterRunner {
+	return s.runner
+}
+
 // SetupChain creates and configures iptables chains
 func (s *IptablesService) SetupChain() error {
 	s.logger.Info().Msg("Настройка цепочек iptables")
@@ -58,113 +70,194 @@ func (s *IptablesService) SetupChain() error {
 // setupIPv4Chain configures IPv4 chain
 func (s *IptablesService) setupIPv4Chain(linkToInput bool) error {
 	s.logger.Debug().Msg("Настройка IPv4 цепочки")
+	return s.applyChainSpec(IPv4, chainName, ipsetV4Name, "ANTISCAN-v4: ", linkToInput)
+}
 
-	// Check if chain exists
-	if s.iptablesCmd.ChainExists(IPv4, TableFilter, chainName) {
-		s.logger.Info().Str("chain", chainName).Msg("Очистка существующей цепочки iptables")
-		if err := s.iptablesCmd.FlushChain(IPv4, TableFilter, chainName); err != nil {
-			return fmt.Errorf("failed to flush chain: %w", err)
-		}
-	} else {
-		s.logger.Info().Str("chain", chainName).Msg("Создание цепочки iptables")
-		if err := s.iptablesCmd.CreateChain(IPv4, TableFilter, chainName); err != nil {
-			return fmt.Errorf("failed to create chain: %w", err)
-		}
-	}
+// setupIPv6Chain configures IPv6 chain
+func (s *IptablesService) setupIPv6Chain(linkToInput bool) error {
+	s.logger.Debug().Msg("Настройка IPv6 цепочки")
+	return s.applyChainSpec(IPv6, chainName, ipsetV6Name, "ANTISCAN-v6: ", linkToInput)
+}
 
-	// Link chain to INPUT (only if not using UFW)
-	if linkToInput {
-		if !s.iptablesCmd.RuleExists(IPv4, TableFilter, string(ChainInput), []string{"-j", chainName}) {
-			s.logger.Info().Msg("Привязка цепочки к INPUT")
-			if err := s.iptablesCmd.LinkChainToInput(IPv4, chainName, 1); err != nil {
-				return fmt.Errorf("failed to link chain to INPUT: %w", err)
-			}
-		}
-	}
+// applyChainSpec builds the desired SCANNERS-BLOCK rule set (optional LOG
+// rule plus the DROP rule) and pushes it in a single atomic operation when
+// the active backend supports it (BatchApplier, i.e. iptables-restore),
+// falling back to one call per rule through the NetfilterRunner interface
+// otherwise (e.g. the nftables backend).
+func (s *IptablesService) applyChainSpec(version IPVersion, chain, ipsetName, logPrefix string, linkToInput bool) error {
+	var rules [][]string
 
-	// Add logging rule if enabled
 	if s.enableLogging {
-		logRule := NewRuleBuilder().
-			MatchSet(ipsetV4Name, "src").
+		rules = append(rules, NewRuleBuilder().
+			MatchSet(ipsetName, "src").
 			MatchLimit("10/min", "5").
 			Jump(TargetLog).
-			LogPrefix("ANTISCAN-v4: ").
+			LogPrefix(logPrefix).
 			LogLevel("4").
-			Build()
-		if !s.iptablesCmd.RuleExists(IPv4, TableFilter, chainName, logRule) {
-			s.logger.Info().Msg("Добавление правила логирования IPv4")
-			if err := s.iptablesCmd.InsertRule(IPv4, TableFilter, chainName, 1, logRule); err != nil {
-				return fmt.Errorf("failed to add LOG rule: %w", err)
-			}
-		}
+			Build())
 	}
 
-	// Add DROP rule
-	dropRule := NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()
-	if !s.iptablesCmd.RuleExists(IPv4, TableFilter, chainName, dropRule) {
-		s.logger.Info().Msg("Добавление правила блокировки IPv4")
-		if err := s.iptablesCmd.AppendRule(IPv4, TableFilter, chainName, dropRule); err != nil {
-			return fmt.Errorf("failed to add DROP rule: %w", err)
-		}
+	rules = append(rules, NewRuleBuilder().MatchSet(ipsetName, "src").Jump(TargetDrop).Build())
+
+	// Only ask for the INPUT link when it isn't already in place, so a
+	// re-applied batch doesn't insert a duplicate "-j SCANNERS-BLOCK" jump.
+	needsLink := linkToInput && !s.iptablesCmd.RuleExists(version, TableFilter, string(ChainInput), []string{"-j", chain})
+	if needsLink {
+		s.logger.Info().Msg("Привязка цепочки к INPUT")
 	}
 
-	return nil
-}
+	spec := ChainSpec{
+		Table:        TableFilter,
+		Chain:        chain,
+		LinkToInput:  needsLink,
+		LinkPosition: 1,
+		Rules:        rules,
+	}
 
-// setupIPv6Chain configures IPv6 chain
-func (s *IptablesService) setupIPv6Chain(linkToInput bool) error {
-	s.logger.Debug().Msg("Настройка IPv6 цепочки")
+	if applier, ok := s.runner.(BatchApplier); ok {
+		s.logger.Info().Str("chain", chain).Msg("Применение правил одним restore batch")
+		return applier.ApplyChainSpec(version, spec)
+	}
 
-	// Check if chain exists
-	if s.iptablesCmd.ChainExists(IPv6, TableFilter, chainName) {
-		s.logger.Info().Str("chain", chainName).Msg("Очистка существующей цепочки ip6tables")
-		if err := s.iptablesCmd.FlushChain(IPv6, TableFilter, chainName); err != nil {
+	return s.applyChainSpecIncremental(version, spec)
+}
+
+// applyChainSpecIncremental is the fallback path for backends that can't
+// apply a ChainSpec atomically (currently the nftables backend): it goes
+// through the NetfilterRunner interface one call at a time, same as before
+// the iptables-restore batching was introduced.
+func (s *IptablesService) applyChainSpecIncremental(version IPVersion, spec ChainSpec) error {
+	if s.iptablesCmd.ChainExists(version, spec.Table, spec.Chain) {
+		s.logger.Info().Str("chain", spec.Chain).Msg("Очистка существующей цепочки")
+		if err := s.runner.FlushChain(version, spec.Table, spec.Chain); err != nil {
 			return fmt.Errorf("failed to flush chain: %w", err)
 		}
 	} else {
-		s.logger.Info().Str("chain", chainName).Msg("Создание цепочки ip6tables")
-		if err := s.iptablesCmd.CreateChain(IPv6, TableFilter, chainName); err != nil {
+		s.logger.Info().Str("chain", spec.Chain).Msg("Создание цепочки")
+		if err := s.runner.EnsureChain(version, spec.Table, spec.Chain); err != nil {
 			return fmt.Errorf("failed to create chain: %w", err)
 		}
 	}
 
-	// Link chain to INPUT (only if not using UFW)
-	if linkToInput {
-		if !s.iptablesCmd.RuleExists(IPv6, TableFilter, string(ChainInput), []string{"-j", chainName}) {
-			s.logger.Info().Msg("Привязка цепочки к INPUT")
-			if err := s.iptablesCmd.LinkChainToInput(IPv6, chainName, 1); err != nil {
-				return fmt.Errorf("failed to link chain to INPUT: %w", err)
-			}
+	if spec.LinkToInput {
+		if err := s.runner.LinkToInput(version, spec.Chain, spec.LinkPosition); err != nil {
+			return fmt.Errorf("failed to link chain to INPUT: %w", err)
 		}
 	}
 
-	// Add logging rule if enabled
-	if s.enableLogging {
-		logRule := NewRuleBuilder().
-			MatchSet(ipsetV6Name, "src").
-			MatchLimit("10/min", "5").
-			Jump(TargetLog).
-			LogPrefix("ANTISCAN-v6: ").
-			LogLevel("4").
-			Build()
-		if !s.iptablesCmd.RuleExists(IPv6, TableFilter, chainName, logRule) {
-			s.logger.Info().Msg("Добавление правила логирования IPv6")
-			if err := s.iptablesCmd.InsertRule(IPv6, TableFilter, chainName, 1, logRule); err != nil {
-				return fmt.Errorf("failed to add LOG rule: %w", err)
-			}
+	for _, rule := range spec.Rules {
+		if err := s.runner.EnsureRule(version, spec.Table, spec.Chain, 0, rule); err != nil {
+			return fmt.Errorf("failed to add rule: %w", err)
 		}
 	}
 
-	// Add DROP rule
-	dropRule := NewRuleBuilder().MatchSet(ipsetV6Name, "src").Jump(TargetDrop).Build()
-	if !s.iptablesCmd.RuleExists(IPv6, TableFilter, chainName, dropRule) {
-		s.logger.Info().Msg("Добавление правила блокировки IPv6")
-		if err := s.iptablesCmd.AppendRule(IPv6, TableFilter, chainName, dropRule); err != nil {
-			return fmt.Errorf("failed to add DROP rule: %w", err)
+	return nil
+}
+
+// DesiredRules returns the SCANNERS-BLOCK rule set as ReconcilerService
+// DesiredRule entries, so a long-running watcher can detect and repair drift
+// caused by an external "iptables -F", "ufw reload" or "netfilter-persistent
+// reload" wiping the chain. Returns nil when the active backend isn't
+// BatchApplier-capable (i.e. the nftables backend): its rules live in raw
+// netlink objects ReconcilerService's iptables-save-based drift checks can't
+// see at all, so there is nothing useful to register.
+func (s *IptablesService) DesiredRules() []DesiredRule {
+	if _, ok := s.runner.(BatchApplier); !ok {
+		return nil
+	}
+
+	var rules []DesiredRule
+	rules = append(rules, desiredChainRules(IPv4, ipsetV4Name, "ANTISCAN-v4: ", s.enableLogging)...)
+	rules = append(rules, desiredChainRules(IPv6, ipsetV6Name, "ANTISCAN-v6: ", s.enableLogging)...)
+	return rules
+}
+
+// desiredChainRules builds the LOG (if enabled) and DROP DesiredRule entries
+// applyChainSpec installs into chainName for version, so DesiredRules stays
+// in lockstep with what SetupChain actually applies.
+func desiredChainRules(version IPVersion, ipsetName, logPrefix string, enableLogging bool) []DesiredRule {
+	var rules []DesiredRule
+
+	if enableLogging {
+		rules = append(rules, DesiredRule{
+			Version: version,
+			Table:   TableFilter,
+			Chain:   chainName,
+			RuleSpec: NewRuleBuilder().
+				MatchSet(ipsetName, "src").
+				MatchLimit("10/min", "5").
+				Jump(TargetLog).
+				LogPrefix(logPrefix).
+				LogLevel("4").
+				Build(),
+		})
+	}
+
+	rules = append(rules, DesiredRule{
+		Version:  version,
+		Table:    TableFilter,
+		Chain:    chainName,
+		RuleSpec: NewRuleBuilder().MatchSet(ipsetName, "src").Jump(TargetDrop).Build(),
+	})
+
+	return rules
+}
+
+// DesiredRuleSet returns the same rule set as DesiredRules, reshaped into a
+// RuleSet for IptablesCommandService.Apply - letting a long-running watcher
+// reconcile drift in O(delta) instead of flushing and re-adding everything.
+// Zero-value (no Table set) when the active backend isn't BatchApplier-
+// capable, mirroring DesiredRules' nil return in that case.
+func (s *IptablesService) DesiredRuleSet() RuleSet {
+	rules := s.DesiredRules()
+	if len(rules) == 0 {
+		return RuleSet{}
+	}
+
+	rs := RuleSet{Table: TableFilter}
+	for _, rule := range rules {
+		r := Rule{Chain: rule.Chain, Spec: rule.RuleSpec}
+		if rule.Version == IPv6 {
+			rs.V6 = append(rs.V6, r)
+		} else {
+			rs.V4 = append(rs.V4, r)
 		}
 	}
+	return rs
+}
 
-	return nil
+// RuleCounts returns the number of rules currently in the SCANNERS-BLOCK
+// chain for IPv4 and IPv6, or 0 if the chain doesn't exist yet. Used by
+// "antiscan status" to report chain state without the caller needing to
+// parse "iptables -L" output itself.
+func (s *IptablesService) RuleCounts() (v4, v6 int, err error) {
+	if s.iptablesCmd.ChainExists(IPv4, TableFilter, chainName) {
+		output, err := s.iptablesCmd.ListChain(IPv4, TableFilter, chainName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list %s (IPv4): %w", chainName, err)
+		}
+		v4 = countRuleLines(output)
+	}
+
+	if s.iptablesCmd.ChainExists(IPv6, TableFilter, chainName) {
+		output, err := s.iptablesCmd.ListChain(IPv6, TableFilter, chainName)
+		if err != nil {
+			return v4, 0, fmt.Errorf("failed to list %s (IPv6): %w", chainName, err)
+		}
+		v6 = countRuleLines(output)
+	}
+
+	return v4, v6, nil
+}
+
+// countRuleLines counts the rule lines in "iptables -L -n -v" output, i.e.
+// every line after the "Chain"/column-header pair.
+func countRuleLines(output string) int {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) <= 2 {
+		return 0
+	}
+	return len(lines) - 2
 }
 
 // Save saves iptables rules using appropriate method
@@ -177,6 +270,12 @@ func (s *IptablesService) Save() error {
 		return s.saveWithUFW()
 	}
 
+	// firewalld is the default on RHEL/Fedora/CentOS/Rocky/Alma
+	if s.cmdSvc.IsServiceActive("firewalld") {
+		s.logger.Info().Msg("firewalld обнаружен - интеграция с firewalld")
+		return s.saveWithFirewalld()
+	}
+
 	// Use netfilter-persistent (should be installed by installer)
 	if !s.cmdSvc.CommandExists("netfilter-persistent") {
 		return fmt.Errorf("netfilter-persistent не установлен. Запустите установку зависимостей")
@@ -460,3 +559,141 @@ func (s *IptablesService) saveWithNetfilterPersistent() error {
 
 	return nil
 }
+
+// saveWithFirewalld registers SCANNERS-BLOCK as a firewalld "direct" rule
+// set, the supported way to inject custom iptables chains on distributions
+// that manage their firewall through firewalld (RHEL/Fedora/CentOS/Rocky/Alma)
+// instead of raw iptables or UFW.
+func (s *IptablesService) saveWithFirewalld() error {
+	// CRITICAL: mirror the UFW preflight - never enable direct rules if SSH
+	// isn't currently permitted in the active zone, or we fence out the operator.
+	if !s.isSSHAllowedInFirewalld() {
+		s.logger.Error().Msg("firewalld активен, но SSH не разрешён в текущей зоне!")
+		s.logger.Error().Msg("Выполните: sudo firewall-cmd --permanent --add-service=ssh && sudo firewall-cmd --reload")
+		return fmt.Errorf("SSH not allowed in the active firewalld zone - aborting to prevent server lockout")
+	}
+
+	steps := [][]string{
+		{"--permanent", "--direct", "--add-chain", "ipv4", "filter", chainName},
+		{"--permanent", "--direct", "--add-rule", "ipv4", "filter", "INPUT", "0", "-j", chainName},
+		{"--permanent", "--direct", "--add-rule", "ipv4", "filter", chainName, "-m", "set", "--match-set", ipsetV4Name, "src", "-j", "DROP"},
+		{"--permanent", "--direct", "--add-chain", "ipv6", "filter", chainName},
+		{"--permanent", "--direct", "--add-rule", "ipv6", "filter", "INPUT", "0", "-j", chainName},
+		{"--permanent", "--direct", "--add-rule", "ipv6", "filter", chainName, "-m", "set", "--match-set", ipsetV6Name, "src", "-j", "DROP"},
+	}
+
+	for _, args := range steps {
+		if err := s.cmdSvc.Run("firewall-cmd", args...); err != nil {
+			return fmt.Errorf("failed to register firewalld direct rule %v: %w", args, err)
+		}
+	}
+
+	s.logger.Info().Msg("Перезагрузка firewalld")
+	if err := s.cmdSvc.Run("firewall-cmd", "--reload"); err != nil {
+		return fmt.Errorf("failed to reload firewalld: %w", err)
+	}
+
+	s.logger.Info().Msg("Правила iptables интегрированы с firewalld")
+	return nil
+}
+
+// isSSHAllowedInFirewalld checks that the zone actually bound to the host's
+// default-route interface currently permits the ssh service or port 22, the
+// same preflight saveWithUFW performs for UFW. Checking the zone the default
+// interface is in - rather than firewalld's *default* zone - matters on
+// cloud/multi-homed hosts where the public interface was explicitly bound to
+// a non-default zone (common via nmcli/cloud-init): "--list-all" with no
+// --zone would silently inspect the wrong zone and report SSH as allowed
+// while the zone that will actually enforce the new DROP/direct rules has no
+// SSH exception.
+func (s *IptablesService) isSSHAllowedInFirewalld() bool {
+	args := []string{"--list-all"}
+	if zone := s.firewalldActiveZone(); zone != "" {
+		args = []string{"--zone=" + zone, "--list-all"}
+	}
+
+	output, err := s.cmdSvc.RunOutput("firewall-cmd", args...)
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(output, "ssh") || strings.Contains(output, "22/tcp")
+}
+
+// firewalldActiveZone resolves the firewalld zone bound to the interface
+// carrying the host's default route, falling back to "" (firewalld's
+// default zone) if the interface or its zone binding can't be determined.
+func (s *IptablesService) firewalldActiveZone() string {
+	iface := s.defaultRouteInterface()
+	if iface == "" {
+		return ""
+	}
+
+	output, err := s.cmdSvc.RunOutput("firewall-cmd", "--get-zone-of-interface="+iface)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(output)
+}
+
+// defaultRouteInterface returns the interface carrying the host's default
+// route ("ip route show default"), or "" if it can't be determined.
+func (s *IptablesService) defaultRouteInterface() string {
+	output, err := s.cmdSvc.RunOutput("ip", "route", "show", "default")
+	if err != nil {
+		return ""
+	}
+
+	fields := strings.Fields(output)
+	for i, field := range fields {
+		if field == "dev" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+
+	return ""
+}
+
+// Teardown unlinks and removes the SCANNERS-BLOCK chain from both the
+// classic INPUT path and the UFW before-input chains, and removes the
+// antiscan-move-rules service Save's UFW integration installs.
+// Best-effort throughout, like Save's per-firewall-manager paths: a jump or
+// chain that's already gone isn't an error.
+func (s *IptablesService) Teardown() error {
+	s.logger.Info().Msg("Удаление цепочек iptables")
+
+	for _, chain := range []string{string(ChainInput), "ufw-before-input"} {
+		if err := s.iptablesCmd.DeleteRule(IPv4, TableFilter, chain, []string{"-j", chainName}); err != nil {
+			s.logger.Debug().Err(err).Str("chain", chain).Msg("Правило уже отсутствует")
+		}
+	}
+	for _, chain := range []string{string(ChainInput), "ufw6-before-input"} {
+		if err := s.iptablesCmd.DeleteRule(IPv6, TableFilter, chain, []string{"-j", chainName}); err != nil {
+			s.logger.Debug().Err(err).Str("chain", chain).Msg("Правило уже отсутствует")
+		}
+	}
+
+	if s.iptablesCmd.ChainExists(IPv4, TableFilter, chainName) {
+		_ = s.iptablesCmd.FlushChain(IPv4, TableFilter, chainName)
+		if err := s.iptablesCmd.DeleteChain(IPv4, TableFilter, chainName); err != nil {
+			s.logger.Warn().Err(err).Msg("Не удалось удалить цепочку SCANNERS-BLOCK (IPv4)")
+		}
+	}
+	if s.iptablesCmd.ChainExists(IPv6, TableFilter, chainName) {
+		_ = s.iptablesCmd.FlushChain(IPv6, TableFilter, chainName)
+		if err := s.iptablesCmd.DeleteChain(IPv6, TableFilter, chainName); err != nil {
+			s.logger.Warn().Err(err).Msg("Не удалось удалить цепочку SCANNERS-BLOCK (IPv6)")
+		}
+	}
+
+	if err := s.cmdSvc.Run("systemctl", "disable", "antiscan-move-rules.service"); err != nil {
+		s.logger.Debug().Err(err).Msg("antiscan-move-rules уже отключен")
+	}
+	if err := os.Remove(MoveRulesServicePath); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn().Err(err).Str("path", MoveRulesServicePath).Msg("Не удалось удалить файл")
+	}
+
+	s.logger.Info().Msg("Цепочки iptables удалены")
+	return nil
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 )
@@ -10,17 +11,74 @@ import (
 // IptablesCommandService provides high-level iptables/ip6tables operations
 type IptablesCommandService struct {
 	logger zerolog.Logger
-	cmdSvc *CommandService
+	cmdSvc Executor
+
+	// waitSeconds, maxRetries, and lockPath tune runLocked's xtables-lock
+	// coordination (see iptables_lock.go); zero means "use the package
+	// default". Set via SetWaitSeconds/SetMaxRetries/SetLockPath rather than
+	// constructor params so the common case (NewIptablesCommandService with
+	// defaults) stays unchanged at every existing call site.
+	waitSeconds int
+	maxRetries  int
+	lockPath    string
+
+	// TraceLogging and TraceRate control the optional rule-tracing mode: when
+	// TraceLogging is true, every AppendRule/InsertRule that ends in a
+	// terminal jump (-j DROP/ACCEPT/REJECT/RETURN or a custom chain) gets a
+	// paired "-j LOG" rule auto-injected immediately before it, rate-limited
+	// to TraceRate (defaults to traceLogDefaultRate if left empty).
+	// DeleteRule removes the companion LOG rule along with the rule it was
+	// injected for. See iptables_trace.go.
+	TraceLogging bool
+	TraceRate    string
+
+	traceMu         sync.Mutex
+	traceIdx        map[string]int
+	traceCompanions map[string][]string
 }
 
 // NewIptablesCommandService creates a new iptables command service
-func NewIptablesCommandService(logger zerolog.Logger, cmdSvc *CommandService) *IptablesCommandService {
+func NewIptablesCommandService(logger zerolog.Logger, cmdSvc Executor) *IptablesCommandService {
 	return &IptablesCommandService{
-		logger: logger,
-		cmdSvc: cmdSvc,
+		logger:          logger,
+		cmdSvc:          cmdSvc,
+		traceIdx:        make(map[string]int),
+		traceCompanions: make(map[string][]string),
 	}
 }
 
+// SetWaitSeconds overrides how many seconds "-w" is given when the
+// installed iptables supports it (default defaultXtablesWaitSeconds).
+func (s *IptablesCommandService) SetWaitSeconds(seconds int) {
+	s.waitSeconds = seconds
+}
+
+// SetMaxRetries overrides how many attempts runLocked gives a command before
+// giving up on xtables lock contention (default defaultXtablesMaxRetries).
+func (s *IptablesCommandService) SetMaxRetries(retries int) {
+	s.maxRetries = retries
+}
+
+// SetLockPath overrides the advisory flock path used when the installed
+// iptables doesn't support "-w" (default: /run/xtables.lock, falling back
+// to /var/run/xtables.lock).
+func (s *IptablesCommandService) SetLockPath(path string) {
+	s.lockPath = path
+}
+
+// SetTraceLogging turns "why did this packet get dropped?" trace mode on or
+// off: while enabled, AppendRule/InsertRule auto-inject a paired LOG rule
+// before every terminal jump they add.
+func (s *IptablesCommandService) SetTraceLogging(enabled bool) {
+	s.TraceLogging = enabled
+}
+
+// SetTraceRate overrides the "-m limit --limit" rate used for auto-injected
+// trace LOG rules (default traceLogDefaultRate).
+func (s *IptablesCommandService) SetTraceRate(rate string) {
+	s.TraceRate = rate
+}
+
 // IPVersion represents IP version
 type IPVersion string
 
@@ -50,6 +108,21 @@ const (
 	ChainPostRouting Chain = "POSTROUTING"
 )
 
+// isBuiltinChain reports whether chainName is one of iptables' built-in
+// chains, which iptables-restore already declares with a policy
+// (":INPUT ACCEPT [0:0]") rather than the custom-chain form
+// (":chainName - [0:0]") EnsureChain emits - callers writing a RestoreBatch
+// for an arbitrary chain name (e.g. IptablesCommandService.Apply) must pick
+// the matching declaration via EnsureBuiltinChain instead of EnsureChain.
+func isBuiltinChain(chainName string) bool {
+	switch Chain(chainName) {
+	case ChainInput, ChainOutput, ChainForward, ChainPreRouting, ChainPostRouting:
+		return true
+	default:
+		return false
+	}
+}
+
 // Target represents iptables target
 type Target string
 
@@ -88,7 +161,7 @@ func (s *IptablesCommandService) CreateChain(version IPVersion, table Table, cha
 		Msg("Creating chain")
 
 	args := []string{"-t", string(table), "-N", chainName}
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
 // DeleteChain deletes a chain
@@ -101,7 +174,7 @@ func (s *IptablesCommandService) DeleteChain(version IPVersion, table Table, cha
 		Msg("Deleting chain")
 
 	args := []string{"-t", string(table), "-X", chainName}
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
 // FlushChain flushes all rules from a chain
@@ -114,7 +187,7 @@ func (s *IptablesCommandService) FlushChain(version IPVersion, table Table, chai
 		Msg("Flushing chain")
 
 	args := []string{"-t", string(table), "-F", chainName}
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
 // FlushAll flushes all rules from all chains
@@ -126,7 +199,7 @@ func (s *IptablesCommandService) FlushAll(version IPVersion, table Table) error
 		Msg("Flushing all chains")
 
 	args := []string{"-t", string(table), "-F"}
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
 // ChainExists checks if a chain exists
@@ -145,8 +218,20 @@ func (s *IptablesCommandService) RuleExists(version IPVersion, table Table, chai
 	return err == nil
 }
 
-// AppendRule appends a rule to a chain
+// AppendRule appends a rule to a chain. When TraceLogging is on and
+// ruleSpec ends in a terminal jump, a paired "-j LOG" rule is appended
+// immediately before it first (see iptables_trace.go).
 func (s *IptablesCommandService) AppendRule(version IPVersion, table Table, chainName string, ruleSpec []string) error {
+	if s.TraceLogging {
+		if target, ok := isTerminalJump(ruleSpec); ok {
+			companion := traceRuleFor(chainName, s.nextTraceIdx(chainName), target, s.TraceRate)
+			if err := s.AppendRule(version, table, chainName, companion); err != nil {
+				return fmt.Errorf("failed to append trace LOG rule: %w", err)
+			}
+			s.recordTraceCompanion(version, table, chainName, ruleSpec, companion)
+		}
+	}
+
 	cmd := s.getCommand(version)
 	s.logger.Debug().
 		Str("version", string(version)).
@@ -155,28 +240,45 @@ func (s *IptablesCommandService) AppendRule(version IPVersion, table Table, chai
 		Msg("Appending rule")
 
 	args := append([]string{"-t", string(table), "-A", chainName}, ruleSpec...)
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
-// InsertRule inserts a rule at the beginning of a chain
+// InsertRule inserts a rule at position in a chain (1 when position is 0).
+// When TraceLogging is on, ruleSpec ends in a terminal jump, and an explicit
+// position was given, a paired "-j LOG" rule is inserted at position first
+// and ruleSpec lands at position+1, immediately after it.
 func (s *IptablesCommandService) InsertRule(version IPVersion, table Table, chainName string, position int, ruleSpec []string) error {
+	mainPosition := position
+
+	if s.TraceLogging && position > 0 {
+		if target, ok := isTerminalJump(ruleSpec); ok {
+			companion := traceRuleFor(chainName, s.nextTraceIdx(chainName), target, s.TraceRate)
+			if err := s.InsertRule(version, table, chainName, position, companion); err != nil {
+				return fmt.Errorf("failed to insert trace LOG rule: %w", err)
+			}
+			s.recordTraceCompanion(version, table, chainName, ruleSpec, companion)
+			mainPosition = position + 1
+		}
+	}
+
 	cmd := s.getCommand(version)
 	s.logger.Debug().
 		Str("version", string(version)).
 		Str("chain", chainName).
-		Int("position", position).
+		Int("position", mainPosition).
 		Strs("rule", ruleSpec).
 		Msg("Inserting rule")
 
 	args := []string{"-t", string(table), "-I", chainName}
-	if position > 0 {
-		args = append(args, fmt.Sprintf("%d", position))
+	if mainPosition > 0 {
+		args = append(args, fmt.Sprintf("%d", mainPosition))
 	}
 	args = append(args, ruleSpec...)
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
-// DeleteRule deletes a rule from a chain
+// DeleteRule deletes a rule from a chain, and its trace LOG companion too if
+// AppendRule/InsertRule injected one for it.
 func (s *IptablesCommandService) DeleteRule(version IPVersion, table Table, chainName string, ruleSpec []string) error {
 	cmd := s.getCommand(version)
 	s.logger.Debug().
@@ -186,7 +288,16 @@ func (s *IptablesCommandService) DeleteRule(version IPVersion, table Table, chai
 		Msg("Deleting rule")
 
 	args := append([]string{"-t", string(table), "-D", chainName}, ruleSpec...)
-	return s.cmdSvc.Run(cmd, args...)
+	if err := s.runLocked(cmd, args); err != nil {
+		return err
+	}
+
+	if companion, ok := s.popTraceCompanion(version, table, chainName, ruleSpec); ok {
+		if err := s.DeleteRule(version, table, chainName, companion); err != nil {
+			s.logger.Warn().Err(err).Msg("Не удалось удалить сопутствующее LOG-правило трассировки")
+		}
+	}
+	return nil
 }
 
 // DeleteRuleByNumber deletes a rule by its number in the chain
@@ -199,7 +310,7 @@ func (s *IptablesCommandService) DeleteRuleByNumber(version IPVersion, table Tab
 		Msg("Deleting rule by number")
 
 	args := []string{"-t", string(table), "-D", chainName, fmt.Sprintf("%d", ruleNum)}
-	return s.cmdSvc.Run(cmd, args...)
+	return s.runLocked(cmd, args)
 }
 
 // ListChain lists all rules in a chain
@@ -227,6 +338,35 @@ func (s *IptablesCommandService) ListAllChains(version IPVersion, table Table) (
 	return s.cmdSvc.RunOutput(cmd, args...)
 }
 
+// RuleLineNumber returns the 1-based position of the first rule in chainName
+// that jumps to target, or 0 if no such rule exists. Used by ChainReconciler
+// to detect when SCANNERS-BLOCK has drifted away from position 1.
+func (s *IptablesCommandService) RuleLineNumber(version IPVersion, table Table, chainName, target string) (int, error) {
+	cmd := s.getCommand(version)
+	args := []string{"-t", string(table), "-L", chainName, "-n", "--line-numbers"}
+	output, err := s.cmdSvc.RunOutputQuiet(cmd, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chain %s: %w", chainName, err)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		// Expect: "<num> <target> <proto> ..."
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] != target {
+			continue
+		}
+		var num int
+		if _, err := fmt.Sscanf(fields[0], "%d", &num); err == nil {
+			return num, nil
+		}
+	}
+
+	return 0, nil
+}
+
 // Save saves iptables rules to a file
 func (s *IptablesCommandService) Save(version IPVersion, path string) error {
 	cmd := s.getCommand(version)
@@ -238,6 +378,58 @@ func (s *IptablesCommandService) Save(version IPVersion, path string) error {
 	return s.cmdSvc.RunShell(fmt.Sprintf("%s-save > %s", cmd, path))
 }
 
+// SaveOutput returns "iptables-save -t <table>" (or ip6tables-save) output
+// for table, in the same restore-file grammar ParseRestoreOutput
+// understands. Used by ReconcilerService's periodic full-table diff pass,
+// which needs to see every rule at once rather than probing one at a time.
+func (s *IptablesCommandService) SaveOutput(version IPVersion, table Table) (string, error) {
+	cmd := s.getCommand(version) + "-save"
+	return s.cmdSvc.RunOutput(cmd, "-t", string(table))
+}
+
+// ChainPolicy returns the policy (ACCEPT, DROP, ...) currently configured for
+// a built-in chain in table, read back from iptables-save/ip6tables-save.
+// Callers must echo this value through EnsureBuiltinChain rather than
+// hardcoding one: a built-in chain's declaration line in an iptables-restore
+// payload always sets its policy, even under --noflush, so blindly declaring
+// ":INPUT ACCEPT" would silently reset an operator's hardened "-P INPUT
+// DROP" policy back to ACCEPT every time the chain is (re)linked.
+func (s *IptablesCommandService) ChainPolicy(version IPVersion, table Table, chainName string) (string, error) {
+	output, err := s.SaveOutput(version, table)
+	if err != nil {
+		return "", fmt.Errorf("failed to read policy for chain %s: %w", chainName, err)
+	}
+
+	policy, ok := ParseRestoreOutput(output).Policies[chainName]
+	if !ok {
+		return "", fmt.Errorf("chain %s not found in %s table", chainName, table)
+	}
+	return policy, nil
+}
+
+// ApplyBatch applies a RestoreBatch atomically via a single
+// "iptables-restore --noflush -T <table>" (or ip6tables-restore) invocation,
+// piping the rendered payload on stdin instead of running one exec per rule.
+// --noflush preserves chains/rules not mentioned in the batch, so this can be
+// used to push just the SCANNERS-BLOCK chain without disturbing the rest of
+// the table.
+func (s *IptablesCommandService) ApplyBatch(version IPVersion, batch *RestoreBatch) error {
+	cmd := s.getCommand(version) + "-restore"
+	payload := batch.Render()
+
+	s.logger.Debug().
+		Str("version", string(version)).
+		Str("table", string(batch.table)).
+		Int("lines", len(strings.Split(payload, "\n"))).
+		Msg("Applying restore batch")
+
+	if err := s.runLockedWithStdin(payload, cmd, []string{"--noflush", "-T", string(batch.table)}); err != nil {
+		return fmt.Errorf("failed to apply %s restore batch: %w", cmd, err)
+	}
+
+	return nil
+}
+
 // Restore restores iptables rules from a file
 func (s *IptablesCommandService) Restore(version IPVersion, path string) error {
 	cmd := s.getCommand(version)
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultXtablesWaitSeconds = 5
+	defaultXtablesMaxRetries  = 5
+
+	// defaultXtablesLockPath and legacyXtablesLockPath mirror the two paths
+	// iptables itself flocks depending on distro layout; taking the same
+	// lock coordinates us with the real binary's own locking, not just with
+	// other antiscan processes.
+	defaultXtablesLockPath = "/run/xtables.lock"
+	legacyXtablesLockPath  = "/var/run/xtables.lock"
+)
+
+// xtablesLockGuard serializes and retries iptables/ip6tables invocations so
+// concurrent writers (UFW, fail2ban, docker's own iptables usage, another
+// antiscan process) don't make "antiscan apply" fail outright just because
+// they happen to be holding the xtables lock at the same moment. This is the
+// same "bestEffort lock" approach Docker's libnetwork uses for kernels where
+// iptables doesn't support "-w" yet.
+type xtablesLockGuard struct {
+	mu sync.Mutex
+
+	waitProbeOnce sync.Once
+	waitSupported bool
+}
+
+// globalXtablesLock is process-wide: every IptablesCommandService instance
+// shares it so two services in the same process can't race each other
+// either.
+var globalXtablesLock xtablesLockGuard
+
+// probeWaitSupport checks once per process whether the installed iptables
+// understands "-w"/"--wait" by running "iptables -w -L -n".
+func (g *xtablesLockGuard) probeWaitSupport(cmdSvc Executor) bool {
+	g.waitProbeOnce.Do(func() {
+		_, err := cmdSvc.RunOutputQuiet("iptables", "-w", "-L", "-n")
+		g.waitSupported = err == nil
+	})
+	return g.waitSupported
+}
+
+// isXtablesLockError reports whether command output indicates xtables lock
+// contention - either iptables' own message, or the raw EWOULDBLOCK text a
+// failed flock surfaces as.
+func isXtablesLockError(output string) bool {
+	return strings.Contains(output, "Another app is currently holding the xtables lock") ||
+		strings.Contains(output, "Resource temporarily unavailable")
+}
+
+// runLocked executes an iptables/ip6tables command, serialized through a
+// process-wide mutex, transparently adding "-w <seconds>" when the installed
+// binary supports it, taking an advisory flock on the same xtables.lock path
+// the binary itself uses when it doesn't, and retrying with jittered
+// exponential backoff when the lock is contended.
+func (s *IptablesCommandService) runLocked(cmd string, args []string) error {
+	g := &globalXtablesLock
+
+	finalArgs := args
+	waitSupported := g.probeWaitSupport(s.cmdSvc)
+	if waitSupported {
+		finalArgs = append([]string{"-w", strconv.Itoa(s.resolvedWaitSeconds())}, args...)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !waitSupported {
+		lockFile, err := acquireFileLock(s.resolvedLockPath())
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", s.resolvedLockPath()).
+				Msg("Не удалось получить файловую блокировку xtables, продолжаем без неё")
+		} else {
+			defer releaseFileLock(lockFile)
+		}
+	}
+
+	var lastErr error
+	backoff := 100 * time.Millisecond
+	for attempt := 0; attempt < s.resolvedMaxRetries(); attempt++ {
+		err := s.cmdSvc.Run(cmd, finalArgs...)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !isXtablesLockError(err.Error()) {
+			return err
+		}
+
+		s.logger.Warn().
+			Int("attempt", attempt+1).
+			Str("command", cmd).
+			Msg("xtables lock занят, повторная попытка")
+		time.Sleep(withJitter(backoff))
+		backoff *= 2
+	}
+
+	return lastErr
+}
+
+// runLockedWithStdin is runLocked's counterpart for iptables-restore/
+// ip6tables-restore invocations, which take their payload on stdin instead
+// of as a final positional argument. It shares the same "-w" probing, flock
+// fallback and retry-on-contention behavior, so ApplyBatch gets the same
+// xtables-lock coordination as every other write path.
+func (s *IptablesCommandService) runLockedWithStdin(stdin, cmd string, args []string) error {
+	g := &globalXtablesLock
+
+	finalArgs := args
+	waitSupported := g.probeWaitSupport(s.cmdSvc)
+	if waitSupported {
+		finalArgs = append([]string{"-w", strconv.Itoa(s.resolvedWaitSeconds())}, args...)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !waitSupported {
+		lockFile, err := acquireFileLock(s.resolvedLockPath())
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", s.resolvedLockPath()).
+				Msg("Не удалось получить файловую блокировку xtables, продолжаем без неё")
+		} else {
+			defer releaseFileLock(lockFile)
+		}
+	}
+
+	var lastErr error
+	backoff := 100 * time.Millisecond
+	for attempt := 0; attempt < s.resolvedMaxRetries(); attempt++ {
+		err := s.cmdSvc.RunWithStdin(stdin, cmd, finalArgs...)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !isXtablesLockError(err.Error()) {
+			return err
+		}
+
+		s.logger.Warn().
+			Int("attempt", attempt+1).
+			Str("command", cmd).
+			Msg("xtables lock занят, повторная попытка")
+		time.Sleep(withJitter(backoff))
+		backoff *= 2
+	}
+
+	return lastErr
+}
+
+func (s *IptablesCommandService) resolvedWaitSeconds() int {
+	if s.waitSeconds > 0 {
+		return s.waitSeconds
+	}
+	return defaultXtablesWaitSeconds
+}
+
+func (s *IptablesCommandService) resolvedMaxRetries() int {
+	if s.maxRetries > 0 {
+		return s.maxRetries
+	}
+	return defaultXtablesMaxRetries
+}
+
+func (s *IptablesCommandService) resolvedLockPath() string {
+	if s.lockPath != "" {
+		return s.lockPath
+	}
+	if _, err := os.Stat("/run"); err == nil {
+		return defaultXtablesLockPath
+	}
+	return legacyXtablesLockPath
+}
+
+// acquireFileLock opens (creating if needed) and exclusively flocks path,
+// blocking until it's free. The caller must releaseFileLock it afterwards.
+func acquireFileLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open xtables lock file %s: %w", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to flock %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// releaseFileLock unlocks and closes a lock file obtained from
+// acquireFileLock, best-effort (nothing useful to do if either call fails).
+func releaseFileLock(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+// withJitter adds up to 50% random extra to d, so multiple processes woken
+// by the same backoff tier don't immediately collide on the lock again.
+func withJitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RestoreBatch accumulates an iptables-restore/ip6tables-restore payload for a
+// single table in memory so the whole chain state can be applied atomically
+// in one "iptables-restore --noflush" invocation instead of a sequence of
+// one-shot "iptables -N/-F/-I/-A" execs.
+type RestoreBatch struct {
+	table        Table
+	declarations []string
+	seen         map[string]bool
+	lines        []string
+}
+
+// NewRestoreBatch creates an empty batch for table.
+func NewRestoreBatch(table Table) *RestoreBatch {
+	return &RestoreBatch{
+		table: table,
+		seen:  make(map[string]bool),
+	}
+}
+
+// EnsureChain declares a user-defined chain, emitting ":chainName - [0:0]"
+// the first time it is seen. Safe to call more than once for the same chain.
+func (b *RestoreBatch) EnsureChain(chainName string) *RestoreBatch {
+	if b.seen[chainName] {
+		return b
+	}
+	b.seen[chainName] = true
+	b.declarations = append(b.declarations, fmt.Sprintf(":%s - [0:0]", chainName))
+	return b
+}
+
+// EnsureBuiltinChain declares a built-in chain (INPUT, FORWARD, ...) with the
+// given policy, e.g. ":INPUT ACCEPT [0:0]". The restore sets the chain's
+// policy to it, so callers pass the chain's current policy (see
+// ChainPolicy) to leave it unchanged. A built-in chain must be
+// declared before it can be referenced by -A/-I when applying with --noflush.
+func (b *RestoreBatch) EnsureBuiltinChain(chainName, policy string) *RestoreBatch {
+	if b.seen[chainName] {
+		return b
+	}
+	b.seen[chainName] = true
+	b.declarations = append(b.declarations, fmt.Sprintf(":%s %s [0:0]", chainName, policy))
+	return b
+}
+
+// Flush adds a "-F chainName" line, clearing all rules from chainName when
+// the batch is applied.
+func (b *RestoreBatch) Flush(chainName string) *RestoreBatch {
+	b.EnsureChain(chainName)
+	b.lines = append(b.lines, fmt.Sprintf("-F %s", chainName))
+	return b
+}
+
+// Append adds an "-A chainName <ruleSpec>" line.
+func (b *RestoreBatch) Append(chainName string, ruleSpec ...string) *RestoreBatch {
+	b.EnsureChain(chainName)
+	b.lines = append(b.lines, fmt.Sprintf("-A %s %s", chainName, renderRuleSpec(ruleSpec)))
+	return b
+}
+
+// Insert adds an "-I chainName position <ruleSpec>" line.
+func (b *RestoreBatch) Insert(chainName string, position int, ruleSpec ...string) *RestoreBatch {
+	b.EnsureChain(chainName)
+	b.lines = append(b.lines, fmt.Sprintf("-I %s %d %s", chainName, position, renderRuleSpec(ruleSpec)))
+	return b
+}
+
+// Delete adds a "-D chainName <ruleSpec>" line.
+func (b *RestoreBatch) Delete(chainName string, ruleSpec ...string) *RestoreBatch {
+	b.lines = append(b.lines, fmt.Sprintf("-D %s %s", chainName, renderRuleSpec(ruleSpec)))
+	return b
+}
+
+// DeleteChain adds a "-X chainName" line, removing a user-defined chain.
+func (b *RestoreBatch) DeleteChain(chainName string) *RestoreBatch {
+	b.lines = append(b.lines, fmt.Sprintf("-X %s", chainName))
+	return b
+}
+
+// Render returns the full iptables-restore payload for this batch:
+//
+//	*<table>
+//	:<chain> - [0:0]
+//	...
+//	-A ...
+//	COMMIT
+func (b *RestoreBatch) Render() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%s\n", b.table)
+	for _, decl := range b.declarations {
+		sb.WriteString(decl)
+		sb.WriteByte('\n')
+	}
+	for _, line := range b.lines {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("COMMIT\n")
+	return sb.String()
+}
+
+// ParsedRestore is the result of parsing an iptables-save/iptables-restore
+// formatted blob: per-table chain declarations and rule lines, following the
+// same grammar Kubernetes' fake iptables restore uses (lines starting with
+// "*", ":", "-A", "-X", "COMMIT"). It is primarily used to compare the
+// currently-installed ruleset against a desired RestoreBatch before writing.
+type ParsedRestore struct {
+	Table    Table
+	Chains   []string
+	Rules    map[string][]string // chain name -> ordered "-A" rule specs (without the leading flag/chain), re-quoted via renderRuleSpec
+	Policies map[string]string   // chain name -> declared policy ("ACCEPT"/"DROP"/...), "-" for user-defined chains
+}
+
+// ParseRestoreOutput parses the output of "iptables-save -t <table>" (or an
+// equivalent restore-format blob) into a ParsedRestore.
+func ParseRestoreOutput(output string) *ParsedRestore {
+	parsed := &ParsedRestore{
+		Rules:    make(map[string][]string),
+		Policies: make(map[string]string),
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "*"):
+			parsed.Table = Table(strings.TrimPrefix(line, "*"))
+		case strings.HasPrefix(line, ":"):
+			fields := strings.Fields(strings.TrimPrefix(line, ":"))
+			if len(fields) > 0 {
+				parsed.Chains = append(parsed.Chains, fields[0])
+			}
+			if len(fields) > 1 {
+				parsed.Policies[fields[0]] = fields[1]
+			}
+		case strings.HasPrefix(line, "-A "):
+			tokens := splitRuleSpec(strings.TrimPrefix(line, "-A "))
+			if len(tokens) == 0 {
+				continue
+			}
+			chain := tokens[0]
+			parsed.Rules[chain] = append(parsed.Rules[chain], renderRuleSpec(tokens[1:]))
+		case strings.HasPrefix(line, "-X "):
+			// Chain deletion: nothing to record, the chain simply won't
+			// appear in parsed.Chains on a fresh read-back.
+		case line == "COMMIT":
+			// end of table
+		}
+	}
+
+	return parsed
+}
+
+// quoteRuleSpecToken quotes tok for embedding in an iptables-restore line
+// when it contains whitespace or a double quote, matching how iptables-save
+// itself quotes multi-word option values (e.g. --log-prefix "ANTISCAN-v4: ")
+// so they survive a restore/save round trip instead of losing everything
+// after the first space, or confusing splitRuleSpec's quote-toggle parsing.
+// Tokens with neither are left bare, the common case.
+func quoteRuleSpecToken(tok string) string {
+	if !strings.ContainsAny(tok, " \t\"") {
+		return tok
+	}
+	return `"` + strings.ReplaceAll(tok, `"`, `\"`) + `"`
+}
+
+// renderRuleSpec joins ruleSpec into the argument portion of an
+// iptables-restore line, quoting whitespace-containing tokens (see
+// quoteRuleSpecToken). Used both to render RestoreBatch lines and to
+// re-canonicalize a rule spec parsed back out of ParseRestoreOutput, so the
+// two are directly comparable.
+func renderRuleSpec(ruleSpec []string) string {
+	quoted := make([]string, len(ruleSpec))
+	for i, tok := range ruleSpec {
+		quoted[i] = quoteRuleSpecToken(tok)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitRuleSpec tokenizes a rule-spec string the way iptables-restore/
+// iptables-save do: whitespace-separated, except for double-quoted spans
+// (used for values containing spaces), which are kept as a single token
+// with the surrounding quotes stripped and \" unescaped.
+func splitRuleSpec(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuotes := false
+	hasToken := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case inQuotes && c == '\\' && i+1 < len(s) && s[i+1] == '"':
+			cur.WriteByte('"')
+			i++
+		case !inQuotes && (c == ' ' || c == '\t'):
+			if hasToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				hasToken = false
+			}
+		default:
+			cur.WriteByte(c)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		tokens = append(tokens, cur.String())
+	}
+
+	return tokens
+}
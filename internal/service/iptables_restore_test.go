@@ -0,0 +1,72 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRestoreBatchQuotesWhitespaceTokens guards against the v4/v6 LOG rules'
+// --log-prefix value ("ANTISCAN-v4: ", with a trailing space) losing
+// everything after its first space when a batch is rendered and a real
+// iptables-restore/iptables-save round trip is simulated via
+// ParseRestoreOutput.
+func TestRestoreBatchQuotesWhitespaceTokens(t *testing.T) {
+	spec := NewRuleBuilder().
+		MatchSet(ipsetV4Name, "src").
+		Jump(TargetLog).
+		LogPrefix("ANTISCAN-v4: ").
+		LogLevel("4").
+		Build()
+
+	batch := NewRestoreBatch(TableFilter)
+	batch.Append(chainName, spec...)
+	rendered := batch.Render()
+
+	if want := `"ANTISCAN-v4: "`; !strings.Contains(rendered, want) {
+		t.Fatalf("expected rendered batch to quote the log prefix, got:\n%s", rendered)
+	}
+
+	parsed := ParseRestoreOutput(rendered)
+	rules := parsed.Rules[chainName]
+	if len(rules) != 1 {
+		t.Fatalf("expected exactly 1 parsed rule, got %d: %v", len(rules), rules)
+	}
+
+	roundTripped := splitRuleSpec(rules[0])
+	if len(roundTripped) != len(spec) {
+		t.Fatalf("round-tripped spec has %d tokens, want %d: %v", len(roundTripped), len(spec), roundTripped)
+	}
+	for i, tok := range spec {
+		if roundTripped[i] != tok {
+			t.Fatalf("token %d: got %q, want %q (full spec: %v)", i, roundTripped[i], tok, roundTripped)
+		}
+	}
+}
+
+// TestRestoreBatchQuotesDoubleQuoteToken guards against a token containing a
+// bare double quote (but no whitespace) being left unquoted: splitRuleSpec
+// treats any unescaped '"' as a quote-open marker, so without quoting such a
+// token would corrupt tokenization of the rest of the line on read-back.
+func TestRestoreBatchQuotesDoubleQuoteToken(t *testing.T) {
+	spec := []string{"-m", "comment", "--comment", `a"b`}
+
+	batch := NewRestoreBatch(TableFilter)
+	batch.Append(chainName, spec...)
+	rendered := batch.Render()
+
+	parsed := ParseRestoreOutput(rendered)
+	rules := parsed.Rules[chainName]
+	if len(rules) != 1 {
+		t.Fatalf("expected exactly 1 parsed rule, got %d: %v", len(rules), rules)
+	}
+
+	roundTripped := splitRuleSpec(rules[0])
+	if len(roundTripped) != len(spec) {
+		t.Fatalf("round-tripped spec has %d tokens, want %d: %v", len(roundTripped), len(spec), roundTripped)
+	}
+	for i, tok := range spec {
+		if roundTripped[i] != tok {
+			t.Fatalf("token %d: got %q, want %q (full spec: %v)", i, roundTripped[i], tok, roundTripped)
+		}
+	}
+}
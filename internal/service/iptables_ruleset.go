@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rule is one canonicalized iptables rule: a chain plus its rule spec for a
+// specific IP version. It's the unit Diff/Apply compare and reconcile by.
+type Rule struct {
+	Version IPVersion
+	Chain   string
+	Spec    []string
+}
+
+// key identifies r for the purposes of Diff: two rules are the same if they
+// share a version, chain, and rule spec, regardless of where in the chain
+// they sit - matching how iptables itself treats "-A"/"-I" as equivalent
+// once applied.
+func (r Rule) key() string {
+	return fmt.Sprintf("%s|%s|%s", r.Version, r.Chain, strings.Join(r.Spec, " "))
+}
+
+// RuleSet holds the intended (or currently-installed) set of rules for a
+// single table, split by IP version so a caller managing both iptables and
+// ip6tables chains can express both halves in one value - the same
+// (version, table) grouping IptablesBatch already uses.
+type RuleSet struct {
+	Table Table
+	V4    []Rule
+	V6    []Rule
+}
+
+// index flattens rs into a map keyed by Rule.key(), stamping each rule with
+// its IPVersion based on which slice it came from.
+func (rs RuleSet) index() map[string]Rule {
+	idx := make(map[string]Rule, len(rs.V4)+len(rs.V6))
+	for _, r := range rs.V4 {
+		r.Version = IPv4
+		idx[r.key()] = r
+	}
+	for _, r := range rs.V6 {
+		r.Version = IPv6
+		idx[r.key()] = r
+	}
+	return idx
+}
+
+// chains returns the distinct chain names rs declares rules for, in
+// first-seen order.
+func (rs RuleSet) chains() []string {
+	seen := make(map[string]bool)
+	var chains []string
+	for _, r := range append(append([]Rule(nil), rs.V4...), rs.V6...) {
+		if !seen[r.Chain] {
+			seen[r.Chain] = true
+			chains = append(chains, r.Chain)
+		}
+	}
+	return chains
+}
+
+// Diff computes the minimal change set turning current into desired: rules
+// present in desired but not current go in toAdd, rules present in current
+// but not desired go in toDelete. Rules present in both are left untouched,
+// so their conntrack state and packet counters survive an Apply.
+func Diff(current, desired RuleSet) (toAdd, toDelete []Rule) {
+	currentIdx := current.index()
+	desiredIdx := desired.index()
+
+	for key, rule := range desiredIdx {
+		if _, ok := currentIdx[key]; !ok {
+			toAdd = append(toAdd, rule)
+		}
+	}
+	for key, rule := range currentIdx {
+		if _, ok := desiredIdx[key]; !ok {
+			toDelete = append(toDelete, rule)
+		}
+	}
+
+	sortRules(toAdd)
+	sortRules(toDelete)
+	return toAdd, toDelete
+}
+
+// sortRules orders rules by their Diff key so toAdd/toDelete (and the
+// resulting IptablesBatch) are deterministic between runs.
+func sortRules(rules []Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].key() < rules[j].key()
+	})
+}
+
+// CurrentRuleSet reads table's live state for both IPv4 and IPv6 via
+// "iptables-save"/"ip6tables-save" and canonicalizes it into a RuleSet,
+// restricted to chains - callers only want Diff to touch the chains they
+// manage, not rules some other tool (ufw, docker) owns in the same table.
+func (s *IptablesCommandService) CurrentRuleSet(table Table, chains ...string) (RuleSet, error) {
+	rs := RuleSet{Table: table}
+
+	v4Output, err := s.SaveOutput(IPv4, table)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("failed to read current ipv4 %s rules: %w", table, err)
+	}
+	rs.V4 = rulesForChains(ParseRestoreOutput(v4Output), chains)
+
+	v6Output, err := s.SaveOutput(IPv6, table)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("failed to read current ipv6 %s rules: %w", table, err)
+	}
+	rs.V6 = rulesForChains(ParseRestoreOutput(v6Output), chains)
+
+	return rs, nil
+}
+
+// rulesForChains extracts parsed's "-A" rule specs for chains into Rules,
+// leaving Version unset - RuleSet.index stamps it based on the V4/V6 slice.
+// Uses splitRuleSpec rather than strings.Fields so a quoted, whitespace-
+// containing value (e.g. --log-prefix "ANTISCAN-v4: ") comes back as the
+// same single token DesiredRuleSet built it with, instead of being split on
+// its internal space and permanently diffing against itself.
+func rulesForChains(parsed *ParsedRestore, chains []string) []Rule {
+	var rules []Rule
+	for _, chain := range chains {
+		for _, spec := range parsed.Rules[chain] {
+			rules = append(rules, Rule{Chain: chain, Spec: splitRuleSpec(spec)})
+		}
+	}
+	return rules
+}
+
+// Apply reconciles the live state of rs.Table against rs: it reads the
+// chains rs declares, diffs them against rs, and applies only the resulting
+// adds/deletes through a single IptablesBatch commit. This turns a config
+// reload into O(delta) instead of "flush chain + re-add everything",
+// preserving conntrack state and packet counters for every rule that didn't
+// change.
+func (s *IptablesCommandService) Apply(rs RuleSet) error {
+	current, err := s.CurrentRuleSet(rs.Table, rs.chains()...)
+	if err != nil {
+		return err
+	}
+
+	toAdd, toDelete := Diff(current, rs)
+	if len(toAdd) == 0 && len(toDelete) == 0 {
+		return nil
+	}
+
+	batch := s.NewBatch()
+	for _, rule := range toDelete {
+		batch.DeleteRule(rule.Version, rs.Table, rule.Chain, rule.Spec...)
+	}
+
+	// Cache each builtin chain's current policy per version so a RuleSet
+	// adding several rules to the same chain only reads it once.
+	type policyKey struct {
+		version IPVersion
+		chain   string
+	}
+	policies := make(map[policyKey]string)
+
+	for _, rule := range toAdd {
+		if isBuiltinChain(rule.Chain) {
+			key := policyKey{rule.Version, rule.Chain}
+			policy, ok := policies[key]
+			if !ok {
+				policy, err = s.ChainPolicy(rule.Version, rs.Table, rule.Chain)
+				if err != nil {
+					return fmt.Errorf("failed to read %s policy: %w", rule.Chain, err)
+				}
+				policies[key] = policy
+			}
+			batch.EnsureBuiltinChain(rule.Version, rs.Table, rule.Chain, policy)
+		} else {
+			batch.CreateChain(rule.Version, rs.Table, rule.Chain)
+		}
+		batch.AppendRule(rule.Version, rs.Table, rule.Chain, rule.Spec...)
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to apply rule set for table %s: %w", rs.Table, err)
+	}
+	return nil
+}
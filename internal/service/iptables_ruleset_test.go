@@ -0,0 +1,142 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRuleSetDiff(t *testing.T) {
+	keep := Rule{Chain: chainName, Spec: []string{"-m", "set", "--match-set", ipsetV4Name, "src", "-j", "DROP"}}
+	stale := Rule{Chain: chainName, Spec: []string{"-m", "set", "--match-set", "OLD-SET", "src", "-j", "DROP"}}
+	fresh := Rule{Chain: chainName, Spec: []string{"-m", "set", "--match-set", ipsetV6Name, "src", "-j", "DROP"}}
+
+	current := RuleSet{Table: TableFilter, V4: []Rule{keep, stale}}
+	desired := RuleSet{Table: TableFilter, V4: []Rule{keep}, V6: []Rule{fresh}}
+
+	toAdd, toDelete := Diff(current, desired)
+
+	if len(toAdd) != 1 || toAdd[0].Version != IPv6 || toAdd[0].Chain != fresh.Chain {
+		t.Fatalf("expected exactly the new ipv6 rule in toAdd, got %+v", toAdd)
+	}
+	if len(toDelete) != 1 || toDelete[0].Version != IPv4 || toDelete[0].Chain != stale.Chain {
+		t.Fatalf("expected exactly the stale ipv4 rule in toDelete, got %+v", toDelete)
+	}
+
+	// Unchanged rules must never show up in either list.
+	for _, r := range append(toAdd, toDelete...) {
+		if r.key() == keep.key() {
+			t.Fatalf("unchanged rule %+v should not appear in the diff", keep)
+		}
+	}
+}
+
+func TestRuleSetDiffNoChanges(t *testing.T) {
+	rule := Rule{Chain: chainName, Spec: []string{"-j", "DROP"}}
+	rs := RuleSet{Table: TableFilter, V4: []Rule{rule}}
+
+	toAdd, toDelete := Diff(rs, rs)
+	if len(toAdd) != 0 || len(toDelete) != 0 {
+		t.Fatalf("expected no diff against an identical rule set, got toAdd=%+v toDelete=%+v", toAdd, toDelete)
+	}
+}
+
+// TestApplyConvergesWithLogPrefixRule runs the same
+// CurrentRuleSet->Diff->Apply path IptablesService.DesiredRuleSet() drives,
+// against a fake iptables-save-formatted round trip, for a rule set that
+// includes a LOG rule with a whitespace-containing --log-prefix value (as
+// built by desiredChainRules). Before the quoting fix this rule could never
+// be read back as equal to itself, so it showed up in both toAdd and
+// toDelete on every Apply - this guards against that regression.
+func TestApplyConvergesWithLogPrefixRule(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+
+	logRule := Rule{
+		Chain: chainName,
+		Spec: NewRuleBuilder().
+			MatchSet(ipsetV4Name, "src").
+			MatchLimit("10/min", "5").
+			Jump(TargetLog).
+			LogPrefix("ANTISCAN-v4: ").
+			LogLevel("4").
+			Build(),
+	}
+	dropRule := Rule{
+		Chain: chainName,
+		Spec:  NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build(),
+	}
+	desired := RuleSet{Table: TableFilter, V4: []Rule{logRule, dropRule}}
+
+	if err := cmd.Apply(desired); err != nil {
+		t.Fatalf("first Apply: %v", err)
+	}
+	if got := len(fake.Rules(string(TableFilter), chainName)); got != 2 {
+		t.Fatalf("expected 2 rules installed after first Apply, got %d: %v", got, fake.Rules(string(TableFilter), chainName))
+	}
+
+	current, err := cmd.CurrentRuleSet(TableFilter, chainName)
+	if err != nil {
+		t.Fatalf("CurrentRuleSet: %v", err)
+	}
+	if toAdd, toDelete := Diff(current, desired); len(toAdd) != 0 || len(toDelete) != 0 {
+		t.Fatalf("expected current state to already match desired, got toAdd=%+v toDelete=%+v", toAdd, toDelete)
+	}
+
+	// A second Apply against the now-converged state must be a no-op: it
+	// must not re-delete and re-add the LOG rule (losing conntrack/counters)
+	// or leave a duplicate behind.
+	if err := cmd.Apply(desired); err != nil {
+		t.Fatalf("second Apply: %v", err)
+	}
+	if got := len(fake.Rules(string(TableFilter), chainName)); got != 2 {
+		t.Fatalf("expected still 2 rules after a converged re-Apply, got %d: %v", got, fake.Rules(string(TableFilter), chainName))
+	}
+}
+
+// TestApplyToBuiltinChainPreservesPolicy guards against a regression where
+// Apply, when a RuleSet adds a rule to a built-in chain (INPUT, FORWARD,
+// ...), always declared that chain with a hardcoded ACCEPT policy instead of
+// the one already configured - silently resetting an operator's hardened
+// policy the first time a caller manages a builtin-chain rule through
+// RuleSet/Diff/Apply.
+func TestApplyToBuiltinChainPreservesPolicy(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+
+	if err := fake.Run("iptables", "-P", "INPUT", "DROP"); err != nil {
+		t.Fatalf("simulated -P INPUT DROP: %v", err)
+	}
+
+	desired := RuleSet{
+		Table: TableFilter,
+		V4: []Rule{
+			{Chain: string(ChainInput), Spec: []string{"-j", chainName}},
+		},
+	}
+	if err := cmd.Apply(desired); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	policy, err := cmd.ChainPolicy(IPv4, TableFilter, string(ChainInput))
+	if err != nil {
+		t.Fatalf("ChainPolicy: %v", err)
+	}
+	if policy != "DROP" {
+		t.Fatalf("expected INPUT policy to remain DROP after Apply, got %q", policy)
+	}
+}
+
+func TestIsBuiltinChain(t *testing.T) {
+	for _, chain := range []string{"INPUT", "OUTPUT", "FORWARD", "PREROUTING", "POSTROUTING"} {
+		if !isBuiltinChain(chain) {
+			t.Errorf("expected %s to be treated as a built-in chain", chain)
+		}
+	}
+
+	// SCANNERS-BLOCK is a chain this package creates itself - it must take
+	// the custom-chain declaration form, not the built-in one.
+	if isBuiltinChain(chainName) {
+		t.Errorf("%s is user-defined, not a built-in chain", chainName)
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestIptablesCommandServiceChainLifecycle(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+
+	if cmd.ChainExists(IPv4, TableFilter, chainName) {
+		t.Fatalf("chain %s should not exist yet", chainName)
+	}
+
+	if err := cmd.CreateChain(IPv4, TableFilter, chainName); err != nil {
+		t.Fatalf("CreateChain: %v", err)
+	}
+	if !cmd.ChainExists(IPv4, TableFilter, chainName) {
+		t.Fatalf("chain %s should exist after CreateChain", chainName)
+	}
+
+	dropRule := NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()
+	if err := cmd.AppendRule(IPv4, TableFilter, chainName, dropRule); err != nil {
+		t.Fatalf("AppendRule: %v", err)
+	}
+	if !cmd.RuleExists(IPv4, TableFilter, chainName, dropRule) {
+		t.Fatalf("expected drop rule to be present in %s", chainName)
+	}
+
+	linkRule := NewRuleBuilder().JumpChain(chainName).Build()
+	if err := cmd.InsertRule(IPv4, TableFilter, string(ChainInput), 1, linkRule); err != nil {
+		t.Fatalf("InsertRule: %v", err)
+	}
+
+	num, err := cmd.RuleLineNumber(IPv4, TableFilter, string(ChainInput), chainName)
+	if err != nil {
+		t.Fatalf("RuleLineNumber: %v", err)
+	}
+	if num != 1 {
+		t.Fatalf("expected %s to be at position 1 in INPUT, got %d", chainName, num)
+	}
+
+	if err := cmd.DeleteRule(IPv4, TableFilter, chainName, dropRule); err != nil {
+		t.Fatalf("DeleteRule: %v", err)
+	}
+	if cmd.RuleExists(IPv4, TableFilter, chainName, dropRule) {
+		t.Fatalf("drop rule should have been removed from %s", chainName)
+	}
+}
+
+func TestIptablesCommandServiceApplyBatch(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+
+	batch := NewRestoreBatch(TableFilter)
+	batch.Flush(chainName)
+	batch.Append(chainName, NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()...)
+
+	if err := cmd.ApplyBatch(IPv4, batch); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+
+	if !fake.ChainExists(string(TableFilter), chainName) {
+		t.Fatalf("expected chain %s to exist after ApplyBatch", chainName)
+	}
+	if len(fake.Rules(string(TableFilter), chainName)) != 1 {
+		t.Fatalf("expected 1 rule in %s after ApplyBatch, got %d", chainName, len(fake.Rules(string(TableFilter), chainName)))
+	}
+}
+
+func TestIptablesCommandServiceTraceLogging(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+	cmd.SetTraceLogging(true)
+
+	if err := cmd.CreateChain(IPv4, TableFilter, chainName); err != nil {
+		t.Fatalf("CreateChain: %v", err)
+	}
+
+	dropRule := NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()
+	if err := cmd.AppendRule(IPv4, TableFilter, chainName, dropRule); err != nil {
+		t.Fatalf("AppendRule: %v", err)
+	}
+
+	rules := fake.Rules(string(TableFilter), chainName)
+	if len(rules) != 2 {
+		t.Fatalf("expected trace LOG rule + DROP rule in %s, got %d: %v", chainName, len(rules), rules)
+	}
+	if !strings.Contains(rules[0], "-j LOG") {
+		t.Fatalf("expected companion LOG rule to precede the DROP rule, got:\n%s", strings.Join(rules, "\n"))
+	}
+	if !strings.HasSuffix(rules[1], strings.Join(dropRule, " ")) {
+		t.Fatalf("expected traced DROP rule to be appended last, got:\n%s", strings.Join(rules, "\n"))
+	}
+
+	if err := cmd.DeleteRule(IPv4, TableFilter, chainName, dropRule); err != nil {
+		t.Fatalf("DeleteRule: %v", err)
+	}
+	if len(fake.Rules(string(TableFilter), chainName)) != 0 {
+		t.Fatalf("expected DeleteRule to remove both the rule and its trace LOG companion, got %v", fake.Rules(string(TableFilter), chainName))
+	}
+}
+
+func TestIptablesBatchCommit(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+
+	batch := cmd.NewBatch()
+	batch.CreateChain(IPv4, TableFilter, chainName)
+	batch.AppendRule(IPv4, TableFilter, chainName, NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()...)
+	batch.AppendRule(IPv6, TableFilter, chainName, NewRuleBuilder().MatchSet(ipsetV6Name, "src").Jump(TargetDrop).Build()...)
+
+	dump := batch.Dump()
+	if !strings.Contains(dump, "# ipv4 filter") || !strings.Contains(dump, "# ipv6 filter") {
+		t.Fatalf("expected Dump to mention both ipv4 and ipv6 filter groups, got:\n%s", dump)
+	}
+
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	v4Rules := fake.RulesForVersion(IPv4, string(TableFilter), chainName)
+	if len(v4Rules) != 1 {
+		t.Fatalf("expected 1 ipv4 rule in %s after Commit, got %d: %v", chainName, len(v4Rules), v4Rules)
+	}
+	v6Rules := fake.RulesForVersion(IPv6, string(TableFilter), chainName)
+	if len(v6Rules) != 1 {
+		t.Fatalf("expected 1 ipv6 rule in %s after Commit, got %d: %v", chainName, len(v6Rules), v6Rules)
+	}
+}
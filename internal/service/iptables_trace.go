@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+const (
+	// xtLogPrefixLimit is the xt_LOG/xt_LOG64 kernel module's hard limit on
+	// "--log-prefix" length (29 bytes, including the terminating NUL the
+	// kernel reserves).
+	xtLogPrefixLimit = 29
+
+	// traceLogDefaultRate is used for auto-injected trace LOG rules when
+	// TraceRate is left unset.
+	traceLogDefaultRate = "10/min"
+)
+
+// isTerminalJump reports whether ruleSpec ends in a jump this package
+// considers "terminal" for trace-logging purposes - i.e. one worth knowing
+// about when a packet hits it. LOG itself is excluded so the companion rule
+// AppendRule/InsertRule inject never triggers tracing recursively.
+func isTerminalJump(ruleSpec []string) (target string, ok bool) {
+	for i := 0; i < len(ruleSpec)-1; i++ {
+		if ruleSpec[i] != "-j" {
+			continue
+		}
+		target = ruleSpec[i+1]
+	}
+	if target == "" || target == string(TargetLog) {
+		return "", false
+	}
+	return target, true
+}
+
+// buildTracePrefix builds a "--log-prefix" value identifying chain, idx and
+// target, truncating to xtLogPrefixLimit with a crc32-based hash suffix when
+// it would otherwise overflow the kernel's limit.
+func buildTracePrefix(chain string, idx int, target string) string {
+	prefix := fmt.Sprintf("%s-%d-%s: ", chain, idx, target)
+	if len(prefix) <= xtLogPrefixLimit {
+		return prefix
+	}
+
+	sum := crc32.ChecksumIEEE([]byte(prefix))
+	suffix := fmt.Sprintf("-%08x: ", sum)
+	keep := xtLogPrefixLimit - len(suffix)
+	if keep < 0 {
+		keep = 0
+	}
+	return prefix[:keep] + suffix
+}
+
+// traceRuleFor builds the companion "-j LOG" rule for chain's idx'th traced
+// rule, rate-limited to rate (traceLogDefaultRate if empty).
+func traceRuleFor(chainName string, idx int, target, rate string) []string {
+	if rate == "" {
+		rate = traceLogDefaultRate
+	}
+	return NewRuleBuilder().
+		MatchLimit(rate, "").
+		Jump(TargetLog).
+		LogPrefix(buildTracePrefix(chainName, idx, target)).
+		LogLevel("4").
+		Build()
+}
+
+// traceCompanionKey identifies the main rule a companion LOG rule was
+// injected for, so DeleteRule can find and remove it too.
+func traceCompanionKey(version IPVersion, table Table, chainName string, ruleSpec []string) string {
+	return fmt.Sprintf("%s|%s|%s|%s", version, table, chainName, strings.Join(ruleSpec, " "))
+}
+
+// nextTraceIdx returns the next companion-rule index for chainName,
+// incrementing the counter each call so successive traced rules in the same
+// chain get distinct log prefixes.
+func (s *IptablesCommandService) nextTraceIdx(chainName string) int {
+	s.traceMu.Lock()
+	defer s.traceMu.Unlock()
+	idx := s.traceIdx[chainName]
+	s.traceIdx[chainName] = idx + 1
+	return idx
+}
+
+// recordTraceCompanion remembers that companion is the trace LOG rule
+// injected ahead of ruleSpec, so DeleteRule can remove it alongside.
+func (s *IptablesCommandService) recordTraceCompanion(version IPVersion, table Table, chainName string, ruleSpec, companion []string) {
+	s.traceMu.Lock()
+	defer s.traceMu.Unlock()
+	key := traceCompanionKey(version, table, chainName, ruleSpec)
+	s.traceCompanions[key] = companion
+}
+
+// popTraceCompanion returns and forgets the trace LOG rule recorded for
+// ruleSpec, if any.
+func (s *IptablesCommandService) popTraceCompanion(version IPVersion, table Table, chainName string, ruleSpec []string) ([]string, bool) {
+	s.traceMu.Lock()
+	defer s.traceMu.Unlock()
+	key := traceCompanionKey(version, table, chainName, ruleSpec)
+	companion, ok := s.traceCompanions[key]
+	if ok {
+		delete(s.traceCompanions, key)
+	}
+	return companion, ok
+}
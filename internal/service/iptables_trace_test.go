@@ -0,0 +1,34 @@
+package service
+
+import "testing"
+
+func TestBuildTracePrefixShortFitsAsIs(t *testing.T) {
+	got := buildTracePrefix("SCANNERS-BLOCK", 0, "DROP")
+	want := "SCANNERS-BLOCK-0-DROP: "
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if len(got) > xtLogPrefixLimit {
+		t.Fatalf("prefix %q (%d bytes) exceeds xt_LOG limit %d", got, len(got), xtLogPrefixLimit)
+	}
+}
+
+func TestBuildTracePrefixTruncatesOverLongTarget(t *testing.T) {
+	got := buildTracePrefix("ufw-before-input", 12, "A-VERY-LONG-CUSTOM-CHAIN-TARGET")
+
+	if len(got) > xtLogPrefixLimit {
+		t.Fatalf("expected prefix to be truncated to at most %d bytes, got %q (%d bytes)", xtLogPrefixLimit, got, len(got))
+	}
+	if got == "" {
+		t.Fatalf("expected a non-empty fallback prefix")
+	}
+}
+
+func TestBuildTracePrefixDiffersForDifferentRulesWhenTruncated(t *testing.T) {
+	a := buildTracePrefix("ufw-before-input", 1, "A-VERY-LONG-CUSTOM-CHAIN-TARGET")
+	b := buildTracePrefix("ufw-before-input", 2, "A-VERY-LONG-CUSTOM-CHAIN-TARGET")
+
+	if a == b {
+		t.Fatalf("expected distinct truncated prefixes for different rule indexes, got identical %q", a)
+	}
+}
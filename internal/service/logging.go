@@ -3,20 +3,29 @@ package service
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 
 	"github.com/rs/zerolog"
 )
 
 // LoggingService handles logging configuration setup
 type LoggingService struct {
-	logger zerolog.Logger
+	logger    zerolog.Logger
+	cmdSvc    *CommandService
+	shipToURL string
 }
 
-// NewLoggingService creates a new logging service
-func NewLoggingService(logger zerolog.Logger) *LoggingService {
+// NewLoggingService creates a new logging service. All external commands
+// (chown/chmod on log files, systemctl) go through cmdSvc so --dry-run
+// suppresses them the same way it does for IpsetService/IptablesService.
+// shipToURL is passed through to the generated antiscan-aggregate.service
+// unit as "--ship-to" (see setupAggregatorService); leave it empty to skip
+// remote shipping.
+func NewLoggingService(logger zerolog.Logger, cmdSvc *CommandService, shipToURL string) *LoggingService {
 	return &LoggingService{
-		logger: logger,
+		logger:    logger,
+		cmdSvc:    cmdSvc,
+		shipToURL: shipToURL,
 	}
 }
 
@@ -39,14 +48,9 @@ func (s *LoggingService) Setup() error {
 		return fmt.Errorf("failed to setup logrotate: %w", err)
 	}
 
-	// Create aggregation script
-	if err := s.setupAggregationScript(); err != nil {
-		return fmt.Errorf("failed to setup aggregation script: %w", err)
-	}
-
-	// Create cron job
-	if err := s.setupCronJob(); err != nil {
-		return fmt.Errorf("failed to setup cron job: %w", err)
+	// Install and start the native Go aggregator daemon
+	if err := s.setupAggregatorService(); err != nil {
+		return fmt.Errorf("failed to setup aggregator service: %w", err)
 	}
 
 	// Reload rsyslog
@@ -56,9 +60,9 @@ func (s *LoggingService) Setup() error {
 
 	s.logger.Info().Msg("Конфигурация логирования готова")
 	s.logger.Info().Msg("  Сырые логи: /var/log/iptables-scanners-{ipv4,ipv6}.log")
-	s.logger.Info().Msg("  Агрегированные: /var/log/iptables-scanners-aggregate.csv (с ASN/netname, обновляются каждые 30 сек)")
+	s.logger.Info().Msg("  Агрегированные: /var/log/iptables-scanners-aggregate.csv (с ASN/netname)")
 	s.logger.Info().Msg("  Rate limit: 10 entries/minute")
-	s.logger.Info().Msg("  Проверить статус: systemctl status antiscan-aggregate.timer")
+	s.logger.Info().Msg("  Проверить статус: systemctl status antiscan-aggregate.service")
 
 	return nil
 }
@@ -89,10 +93,10 @@ func (s *LoggingService) createLogFiles() error {
 			f.Close()
 
 			// Set permissions
-			if err := exec.Command("chown", "syslog:adm", logFile).Run(); err != nil {
+			if err := s.cmdSvc.Run("chown", "syslog:adm", logFile); err != nil {
 				s.logger.Warn().Err(err).Str("file", logFile).Msg("Failed to chown log file")
 			}
-			if err := exec.Command("chmod", "640", logFile).Run(); err != nil {
+			if err := s.cmdSvc.Run("chmod", "640", logFile); err != nil {
 				s.logger.Warn().Err(err).Str("file", logFile).Msg("Failed to chmod log file")
 			}
 
@@ -113,58 +117,79 @@ func (s *LoggingService) setupLogrotate() error {
 	return nil
 }
 
-// setupAggregationScript creates the log aggregation shell script
-func (s *LoggingService) setupAggregationScript() error {
-	if err := os.WriteFile(AggregateLogsScriptPath, []byte(AggregateLogsScriptTemplate), 0755); err != nil {
-		return fmt.Errorf("failed to write aggregator script: %w", err)
-	}
-
-	// Ensure it's executable
-	if err := exec.Command("chmod", "+x", AggregateLogsScriptPath).Run(); err != nil {
-		return fmt.Errorf("failed to make script executable: %w", err)
-	}
-
-	s.logger.Info().Str("path", AggregateLogsScriptPath).Msg("Создан скрипт агрегирования логов")
-	return nil
-}
-
-// setupCronJob creates systemd timer for log aggregation (runs every 30 seconds)
-func (s *LoggingService) setupCronJob() error {
-	// Create systemd service
-	if err := os.WriteFile(AggregateLogsServicePath, []byte(AggregateLogsServiceTemplate), 0644); err != nil {
+// setupAggregatorService installs the systemd unit for the native Go
+// aggregator daemon ("antiscan aggregate") and starts it. Unlike the old
+// bash-script-plus-timer pair, this is a single long-running service: the
+// daemon tails the scanner logs continuously and aggregates on its own
+// internal ticker instead of being invoked from scratch every 30 seconds.
+func (s *LoggingService) setupAggregatorService() error {
+	content := AggregateLogsServiceTemplate
+	if s.shipToURL != "" {
+		content = strings.Replace(content,
+			"ExecStart=/usr/local/bin/antiscan aggregate",
+			fmt.Sprintf("ExecStart=/usr/local/bin/antiscan aggregate --ship-to %s", s.shipToURL),
+			1)
+	}
+
+	if err := os.WriteFile(AggregateLogsServicePath, []byte(content), 0644); err != nil {
 		return err
 	}
 	s.logger.Info().Str("path", AggregateLogsServicePath).Msg("Создан systemd сервис")
 
-	// Create systemd timer
-	if err := os.WriteFile(AggregateLogsTimerPath, []byte(AggregateLogsTimerTemplate), 0644); err != nil {
-		return err
-	}
-	s.logger.Info().Str("path", AggregateLogsTimerPath).Msg("Создан systemd timer")
-
 	// Reload systemd daemon
-	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
+	if err := s.cmdSvc.DaemonReload(); err != nil {
 		s.logger.Warn().Err(err).Msg("Не удалось перезапустить systemd daemon")
 	}
 
-	// Enable and start timer
-	if err := exec.Command("systemctl", "enable", "antiscan-aggregate.timer").Run(); err != nil {
+	// Enable and start the service
+	if err := s.cmdSvc.EnableService("antiscan-aggregate.service"); err != nil {
 		s.logger.Warn().Err(err).Msg("Не удалось включить antiscan-aggregate")
 	}
 
-	if err := exec.Command("systemctl", "start", "antiscan-aggregate.timer").Run(); err != nil {
-		s.logger.Warn().Err(err).Msg("Не удалось включить timer")
+	if err := s.cmdSvc.StartService("antiscan-aggregate.service"); err != nil {
+		s.logger.Warn().Err(err).Msg("Не удалось запустить antiscan-aggregate")
 	}
 
-	s.logger.Info().Msg("Systemd timer включен и запущен (каждые 30 секунд)")
+	s.logger.Info().Msg("Сервис агрегации логов включен и запущен")
 	return nil
 }
 
 // reloadRsyslog restarts rsyslog service
 func (s *LoggingService) reloadRsyslog() error {
-	if err := exec.Command("systemctl", "restart", "rsyslog").Run(); err != nil {
+	if err := s.cmdSvc.RestartService("rsyslog"); err != nil {
 		return err
 	}
 	s.logger.Info().Msg("Rsyslog перезапущен")
 	return nil
 }
+
+// Teardown undoes Setup: stops and disables the aggregator service and
+// removes the rsyslog/logrotate/systemd artifacts it wrote. Best-effort,
+// same as Setup - a missing file or an already-stopped service isn't fatal,
+// since uninstall must succeed even after a partial or repeated Setup.
+func (s *LoggingService) Teardown() error {
+	s.logger.Info().Msg("Удаление конфигурации логирования")
+
+	if err := s.cmdSvc.Run("systemctl", "stop", "antiscan-aggregate.service"); err != nil {
+		s.logger.Debug().Err(err).Msg("antiscan-aggregate уже остановлен")
+	}
+	if err := s.cmdSvc.Run("systemctl", "disable", "antiscan-aggregate.service"); err != nil {
+		s.logger.Debug().Err(err).Msg("antiscan-aggregate уже отключен")
+	}
+
+	for _, path := range []string{AggregateLogsServicePath, RsyslogConfigPath, LogrotateConfigPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn().Err(err).Str("path", path).Msg("Не удалось удалить файл")
+		}
+	}
+
+	if err := s.cmdSvc.DaemonReload(); err != nil {
+		s.logger.Warn().Err(err).Msg("Не удалось перезагрузить systemd daemon")
+	}
+	if err := s.reloadRsyslog(); err != nil {
+		s.logger.Warn().Err(err).Msg("Не удалось перезапустить rsyslog")
+	}
+
+	s.logger.Info().Msg("Конфигурация логирования удалена")
+	return nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// NetfilterRunner abstracts the underlying packet-filter backend so that
+// IptablesService does not need to know whether the host is managed through
+// classic iptables/ip6tables or through nftables directly. Both backends
+// operate on the same chain/rule vocabulary used throughout this package.
+type NetfilterRunner interface {
+	// EnsureChain makes sure chainName exists in table, creating it if necessary.
+	EnsureChain(version IPVersion, table Table, chainName string) error
+
+	// EnsureRule makes sure ruleSpec is present in chainName, inserting it at
+	// position (or appending when position <= 0) if it is missing. It is a
+	// no-op if the rule already exists.
+	EnsureRule(version IPVersion, table Table, chainName string, position int, ruleSpec []string) error
+
+	// DeleteRule removes ruleSpec from chainName if present.
+	DeleteRule(version IPVersion, table Table, chainName string, ruleSpec []string) error
+
+	// FlushChain removes all rules from chainName without deleting the chain itself.
+	FlushChain(version IPVersion, table Table, chainName string) error
+
+	// LinkToInput makes sure chainName is jumped to from the INPUT chain at position.
+	LinkToInput(version IPVersion, chainName string, position int) error
+
+	// Save persists the current ruleset so it survives a reboot.
+	Save() error
+
+	// SyncSet replaces the member addresses the DROP rule installed by
+	// EnsureRule matches against for version. It only does real work on
+	// backends that hold their own copy of set membership (the nftables
+	// backend's native inet sets); iptablesRunner's "-m set --match-set"
+	// rule already matches the kernel ipset IpsetService itself maintains,
+	// so it has nothing to sync and no-ops.
+	SyncSet(version IPVersion, subnets []string) error
+}
+
+// detectNetfilterBackend picks the preferred NetfilterRunner for the running host.
+func detectNetfilterBackend(logger zerolog.Logger, cmdSvc Executor) NetfilterRunner {
+	iptablesCmd := NewIptablesCommandService(logger, cmdSvc)
+
+	if preferNftables(cmdSvc) {
+		runner, err := newNftablesRunner(logger)
+		if err == nil {
+			logger.Info().Msg("Обнаружен nftables - используется nftables backend")
+			return runner
+		}
+		logger.Warn().Err(err).Msg("Не удалось инициализировать nftables backend, используется iptables-legacy")
+	}
+
+	return &iptablesRunner{cmd: iptablesCmd}
+}
+
+// preferNftables reports whether the host looks like an nftables-only system:
+// the nf_tables kernel subsystem is loaded and the installed "iptables" binary
+// is the nft-backed compatibility shim (its --version output mentions
+// "(nf_tables)"), meaning there is no real legacy backend to talk to and the
+// nftables API should be used directly instead.
+func preferNftables(cmdSvc Executor) bool {
+	if _, err := os.Stat("/proc/net/nf_tables"); err != nil {
+		return false
+	}
+
+	output, err := cmdSvc.RunOutput("iptables", "--version")
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(output, "(nf_tables)")
+}
+
+// iptablesRunner implements NetfilterRunner on top of the classic
+// iptables/ip6tables command-line tools via IptablesCommandService.
+type iptablesRunner struct {
+	cmd *IptablesCommandService
+}
+
+func (r *iptablesRunner) EnsureChain(version IPVersion, table Table, chainName string) error {
+	if r.cmd.ChainExists(version, table, chainName) {
+		return nil
+	}
+	return r.cmd.CreateChain(version, table, chainName)
+}
+
+func (r *iptablesRunner) EnsureRule(version IPVersion, table Table, chainName string, position int, ruleSpec []string) error {
+	if r.cmd.RuleExists(version, table, chainName, ruleSpec) {
+		return nil
+	}
+	if position > 0 {
+		return r.cmd.InsertRule(version, table, chainName, position, ruleSpec)
+	}
+	return r.cmd.AppendRule(version, table, chainName, ruleSpec)
+}
+
+func (r *iptablesRunner) DeleteRule(version IPVersion, table Table, chainName string, ruleSpec []string) error {
+	return r.cmd.DeleteRule(version, table, chainName, ruleSpec)
+}
+
+func (r *iptablesRunner) FlushChain(version IPVersion, table Table, chainName string) error {
+	return r.cmd.FlushChain(version, table, chainName)
+}
+
+func (r *iptablesRunner) LinkToInput(version IPVersion, chainName string, position int) error {
+	rule := NewRuleBuilder().JumpChain(chainName).Build()
+	return r.EnsureRule(version, TableFilter, string(ChainInput), position, rule)
+}
+
+func (r *iptablesRunner) Save() error {
+	if err := r.cmd.Save(IPv4, IptablesRulesV4Path); err != nil {
+		return err
+	}
+	return r.cmd.Save(IPv6, IptablesRulesV6Path)
+}
+
+// SyncSet is a no-op: the rules this backend installs match the kernel
+// ipset directly ("-m set --match-set"), so there is no second copy of set
+// membership to keep in sync.
+func (r *iptablesRunner) SyncSet(version IPVersion, subnets []string) error {
+	return nil
+}
+
+// ChainSpec describes the desired state of a single chain: whether it must
+// be linked into INPUT, and the ordered rule specs it should contain.
+type ChainSpec struct {
+	Table        Table
+	Chain        string
+	LinkToInput  bool
+	LinkPosition int
+	Rules        [][]string
+}
+
+// BatchApplier is implemented by NetfilterRunner backends that can apply a
+// whole ChainSpec atomically instead of one exec per rule. iptablesRunner
+// implements it via a single iptables-restore/ip6tables-restore invocation;
+// nftablesRunner does not, since it expresses blocking through set membership
+// rather than per-rule inserts.
+type BatchApplier interface {
+	ApplyChainSpec(version IPVersion, spec ChainSpec) error
+}
+
+// ApplyChainSpec builds a RestoreBatch for spec and applies it in one atomic
+// iptables-restore/ip6tables-restore call: flush the chain (or declare it if
+// new), link it into INPUT if requested, then append every desired rule.
+// Linking reads INPUT's current policy first and echoes it back via
+// EnsureBuiltinChain, since declaring the chain is what makes it addressable
+// by the "-j" jump inserted below and that declaration line always sets the
+// chain's policy - the batch must not let it drift from whatever policy the
+// operator already has in place.
+func (r *iptablesRunner) ApplyChainSpec(version IPVersion, spec ChainSpec) error {
+	batch := NewRestoreBatch(spec.Table)
+	batch.EnsureChain(spec.Chain)
+	batch.Flush(spec.Chain)
+
+	if spec.LinkToInput {
+		policy, err := r.cmd.ChainPolicy(version, spec.Table, string(ChainInput))
+		if err != nil {
+			return fmt.Errorf("failed to read INPUT chain policy: %w", err)
+		}
+		batch.EnsureBuiltinChain(string(ChainInput), policy)
+		position := spec.LinkPosition
+		if position <= 0 {
+			position = 1
+		}
+		batch.Insert(string(ChainInput), position, "-j", spec.Chain)
+	}
+
+	for _, rule := range spec.Rules {
+		batch.Append(spec.Chain, rule...)
+	}
+
+	return r.cmd.ApplyBatch(version, batch)
+}
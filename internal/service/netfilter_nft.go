@@ -0,0 +1,298 @@
+package service
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+// nftTableFamily is the single address-family-agnostic table TrafficGuard
+// manages; both the IPv4 and IPv6 sets live inside it.
+const nftTableFamily = nftables.TableFamilyINet
+
+// nftChainName is the nftables equivalent of chainName, lower-cased to match
+// nft's own naming convention.
+const nftChainName = "scanners-block"
+
+// nftablesRunner implements NetfilterRunner using github.com/google/nftables,
+// talking to the kernel over netlink instead of shelling out to iptables.
+// It is selected by detectNetfilterBackend on hosts where iptables is only a
+// compatibility shim over nf_tables.
+type nftablesRunner struct {
+	logger zerolog.Logger
+	conn   *nftables.Conn
+	table  *nftables.Table
+	chain  *nftables.Chain
+	setV4  *nftables.Set
+	setV6  *nftables.Set
+
+	// dropRuleInstalled tracks, per IP version, whether EnsureRule has
+	// already added the set-match DROP rule this process's lifetime, so
+	// repeated SetupChain calls don't stack duplicate rules in the chain.
+	dropRuleInstalled map[IPVersion]bool
+}
+
+// newNftablesRunner opens a netlink connection and makes sure the inet
+// table, the scanners-block chain and the v4/v6 member sets exist.
+func newNftablesRunner(logger zerolog.Logger) (*nftablesRunner, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nftables netlink connection: %w", err)
+	}
+
+	table := conn.AddTable(&nftables.Table{
+		Family: nftTableFamily,
+		Name:   "inet",
+	})
+
+	chain := conn.AddChain(&nftables.Chain{
+		Name:    nftChainName,
+		Table:   table,
+		Type:    nftables.ChainTypeFilter,
+		Hooknum: nftables.ChainHookInput,
+		// One below the kernel's default filter priority (0), so
+		// scanners-block runs just above the usual accept-established rules.
+		Priority: nftables.ChainPriorityRef(-1),
+	})
+
+	setV4 := &nftables.Set{
+		Table:   table,
+		Name:    ipsetV4Name,
+		KeyType: nftables.TypeIPAddr,
+		// Interval lets each element describe a whole CIDR range instead of a
+		// single address, and AutoMerge coalesces adjacent/overlapping ranges
+		// SyncSet adds so repeated syncs don't pile up redundant intervals.
+		Interval:  true,
+		AutoMerge: true,
+	}
+	if err := conn.AddSet(setV4, nil); err != nil {
+		return nil, fmt.Errorf("failed to create nftables set %s: %w", ipsetV4Name, err)
+	}
+
+	setV6 := &nftables.Set{
+		Table:     table,
+		Name:      ipsetV6Name,
+		KeyType:   nftables.TypeIP6Addr,
+		Interval:  true,
+		AutoMerge: true,
+	}
+	if err := conn.AddSet(setV6, nil); err != nil {
+		return nil, fmt.Errorf("failed to create nftables set %s: %w", ipsetV6Name, err)
+	}
+
+	if err := conn.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to apply base nftables ruleset: %w", err)
+	}
+
+	return &nftablesRunner{
+		logger:            logger,
+		conn:              conn,
+		table:             table,
+		chain:             chain,
+		setV4:             setV4,
+		setV6:             setV6,
+		dropRuleInstalled: make(map[IPVersion]bool),
+	}, nil
+}
+
+// setFor returns the member set matching version.
+func (r *nftablesRunner) setFor(version IPVersion) *nftables.Set {
+	if version == IPv6 {
+		return r.setV6
+	}
+	return r.setV4
+}
+
+// srcAddrOffsetLen returns the offset and length of the source address field
+// within the IP network header for version, so EnsureRule can load it with a
+// raw expr.Payload match (IPv4 saddr sits at byte 12, IPv6 saddr at byte 8).
+func srcAddrOffsetLen(version IPVersion) (offset, length uint32) {
+	if version == IPv6 {
+		return 8, 16
+	}
+	return 12, 4
+}
+
+// nfProto returns the NFPROTO_* value identifying version at the network
+// layer, used to keep the IPv4 and IPv6 rules in this shared inet-family
+// chain from matching on each other's payload layout.
+func nfProto(version IPVersion) byte {
+	if version == IPv6 {
+		return unix.NFPROTO_IPV6
+	}
+	return unix.NFPROTO_IPV4
+}
+
+// EnsureChain is a no-op for nftablesRunner: the scanners-block chain and its
+// hook into input are created once in newNftablesRunner.
+func (r *nftablesRunner) EnsureChain(version IPVersion, table Table, chainName string) error {
+	return nil
+}
+
+// EnsureRule only understands the DROP-on-set-match rule SetupChain installs;
+// the actual blocking decision is made by syncing IP entries into setV4/setV6
+// via SyncSet, which the ipset backend keeps up to date. It installs, for
+// version, the equivalent of:
+//
+//	nft add rule inet inet scanners-block <ip|ip6> saddr @<set> drop
+//
+// skipping the add if this runner already installed it earlier in its
+// lifetime (SetupChain calls EnsureRule once per version on every startup).
+func (r *nftablesRunner) EnsureRule(version IPVersion, table Table, chainName string, position int, ruleSpec []string) error {
+	if r.dropRuleInstalled[version] {
+		return nil
+	}
+
+	offset, length := srcAddrOffsetLen(version)
+	set := r.setFor(version)
+
+	r.conn.AddRule(&nftables.Rule{
+		Table: r.table,
+		Chain: r.chain,
+		Exprs: []expr.Any{
+			// Restrict this rule to packets of the matching address family,
+			// since both IPv4 and IPv6 traffic pass through the same inet
+			// chain and the payload offsets below only make sense for one.
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     []byte{nfProto(version)},
+			},
+			// Load the source address and match it against the member set;
+			// a miss simply lets this rule not match, falling through to
+			// whatever comes after it in the chain.
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       offset,
+				Len:          length,
+			},
+			&expr.Lookup{
+				SourceRegister: 1,
+				SetName:        set.Name,
+			},
+			&expr.Verdict{
+				Kind: expr.VerdictDrop,
+			},
+		},
+	})
+	if err := r.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to install nftables drop rule for %s: %w", version, err)
+	}
+
+	r.dropRuleInstalled[version] = true
+	return nil
+}
+
+// DeleteRule is unsupported for the nftables backend: rules are expressed
+// declaratively via the member set, so dropping an entry means removing it
+// from the set rather than deleting a rule.
+func (r *nftablesRunner) DeleteRule(version IPVersion, table Table, chainName string, ruleSpec []string) error {
+	return fmt.Errorf("nftables backend: delete individual rules via SyncSet instead")
+}
+
+// FlushChain removes all rules installed in the scanners-block chain.
+func (r *nftablesRunner) FlushChain(version IPVersion, table Table, chainName string) error {
+	r.conn.FlushChain(r.chain)
+	return r.conn.Flush()
+}
+
+// LinkToInput is a no-op: the chain is already hooked into input at
+// ChainPriorityFilter-1 (just above the kernel's accept-established rules).
+func (r *nftablesRunner) LinkToInput(version IPVersion, chainName string, position int) error {
+	return nil
+}
+
+// Save persists nothing for the nftables backend: it always returns an
+// error, and the table, chain and sets this runner creates are not written
+// to /etc/nftables.conf.
+func (r *nftablesRunner) Save() error {
+	return fmt.Errorf("nftables backend: persistence is handled by nftables.service, not Save()")
+}
+
+// SyncSet replaces the contents of the v4 or v6 member set with subnets,
+// mirroring what the ipset backend holds so the scanners-block chain blocks
+// exactly the same addresses regardless of which backend is active. Each
+// subnet becomes a single interval element spanning its full CIDR range
+// (setV4/setV6 are created with Interval: true for exactly this), not just
+// its first address.
+func (r *nftablesRunner) SyncSet(version IPVersion, subnets []string) error {
+	set := r.setFor(version)
+
+	elements := make([]nftables.SetElement, 0, len(subnets))
+	for _, subnet := range subnets {
+		start, end, err := cidrRangeBytes(subnet, version)
+		if err != nil {
+			r.logger.Warn().Str("subnet", subnet).Err(err).Msg("Пропуск некорректной подсети для nftables set")
+			continue
+		}
+
+		elements = append(elements, nftables.SetElement{Key: start, KeyEnd: end})
+	}
+
+	r.conn.FlushSet(set)
+	if err := r.conn.SetAddElements(set, elements); err != nil {
+		return fmt.Errorf("failed to sync nftables set: %w", err)
+	}
+
+	return r.conn.Flush()
+}
+
+// cidrRangeBytes parses subnet (a CIDR or a bare IP) into the inclusive start
+// and exclusive end address of its range, in the raw byte form version's
+// nftables.SetElement.Key/KeyEnd expect. A bare IP is treated as a /32 or
+// /128, i.e. a single-address range.
+func cidrRangeBytes(subnet string, version IPVersion) (start, end []byte, err error) {
+	ip, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		parsed := net.ParseIP(subnet)
+		if parsed == nil {
+			return nil, nil, fmt.Errorf("invalid subnet %q", subnet)
+		}
+		start = addrBytes(parsed, version)
+		if start == nil {
+			return nil, nil, fmt.Errorf("subnet %q does not match address family %s", subnet, version)
+		}
+		return start, incrementAddr(append([]byte(nil), start...)), nil
+	}
+
+	start = addrBytes(ip.Mask(ipnet.Mask), version)
+	if start == nil {
+		return nil, nil, fmt.Errorf("subnet %q does not match address family %s", subnet, version)
+	}
+
+	end = append([]byte(nil), start...)
+	ones, bits := ipnet.Mask.Size()
+	for bitIdx := bits - 1; bitIdx >= ones; bitIdx-- {
+		end[bitIdx/8] |= 1 << uint(7-bitIdx%8)
+	}
+
+	return start, incrementAddr(end), nil
+}
+
+// addrBytes returns ip in the fixed-width form matching version (4 bytes for
+// IPv4, 16 for IPv6), or nil if ip is not a valid address of that family.
+func addrBytes(ip net.IP, version IPVersion) []byte {
+	if version == IPv6 {
+		return ip.To16()
+	}
+	return ip.To4()
+}
+
+// incrementAddr adds 1 to addr in place, treating it as a big-endian integer,
+// and returns it - used to turn a range's inclusive last address (the CIDR
+// broadcast address) into the exclusive KeyEnd nftables.SetElement expects.
+func incrementAddr(addr []byte) []byte {
+	for i := len(addr) - 1; i >= 0; i-- {
+		addr[i]++
+		if addr[i] != 0 {
+			break
+		}
+	}
+	return addr
+}
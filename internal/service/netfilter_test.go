@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// TestApplyChainSpecPreservesInputPolicy guards against a regression where
+// linking a chain into INPUT via ApplyChainSpec's restore batch always
+// declared INPUT with a hardcoded "ACCEPT" policy. An iptables-restore
+// chain declaration line sets the chain's policy unconditionally, even
+// under --noflush and even though INPUT already exists, so that hardcoded
+// declaration silently reset an operator's hardened "-P INPUT DROP" policy
+// back to ACCEPT on every (re)link.
+func TestApplyChainSpecPreservesInputPolicy(t *testing.T) {
+	fake := NewFakeExecutor()
+	cmd := NewIptablesCommandService(zerolog.Nop(), fake)
+	runner := &iptablesRunner{cmd: cmd}
+
+	// Simulate an operator who has already hardened the default policy.
+	if err := fake.Run("iptables", "-P", "INPUT", "DROP"); err != nil {
+		t.Fatalf("simulated -P INPUT DROP: %v", err)
+	}
+
+	spec := ChainSpec{
+		Table:        TableFilter,
+		Chain:        chainName,
+		LinkToInput:  true,
+		LinkPosition: 1,
+		Rules:        [][]string{NewRuleBuilder().MatchSet(ipsetV4Name, "src").Jump(TargetDrop).Build()},
+	}
+	if err := runner.ApplyChainSpec(IPv4, spec); err != nil {
+		t.Fatalf("ApplyChainSpec: %v", err)
+	}
+
+	policy, err := cmd.ChainPolicy(IPv4, TableFilter, string(ChainInput))
+	if err != nil {
+		t.Fatalf("ChainPolicy: %v", err)
+	}
+	if policy != "DROP" {
+		t.Fatalf("expected INPUT policy to remain DROP after ApplyChainSpec, got %q", policy)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/rs/zerolog"
+)
+
+// NotifyReady tells systemd the service has finished starting up (READY=1).
+// It is a safe no-op when NOTIFY_SOCKET isn't set, e.g. running outside
+// systemd or from a unit that isn't Type=notify.
+func NotifyReady() error {
+	_, err := daemon.SdNotify(false, daemon.SdNotifyReady)
+	return err
+}
+
+// NotifyStatus reports free-form progress text via STATUS=, surfaced by
+// "systemctl status" in place of having to tail the journal. go-systemd/v22
+// doesn't export a STATUS= constant (only the fixed READY=1/STOPPING=1/...
+// states do), so the assignment is built by hand here.
+func NotifyStatus(format string, args ...any) error {
+	_, err := daemon.SdNotify(false, "STATUS="+fmt.Sprintf(format, args...))
+	return err
+}
+
+// NotifyStopping tells systemd the service is beginning shutdown
+// (STOPPING=1), so a manual "systemctl stop" doesn't get logged as an
+// unexpected exit.
+func NotifyStopping() error {
+	_, err := daemon.SdNotify(false, daemon.SdNotifyStopping)
+	return err
+}
+
+// WatchdogMonitor pings systemd's watchdog (WATCHDOG=1) at half the
+// interval configured via the unit's WatchdogSec=, but only after a
+// self-check confirms the SCANNERS-BLOCK-V4/V6 ipsets still exist and the
+// SCANNERS-BLOCK chain is still referenced from INPUT. Skipping the ping on
+// a failed self-check lets systemd's own watchdog timeout restart the unit
+// instead of the monitor papering over a broken state.
+type WatchdogMonitor struct {
+	logger      zerolog.Logger
+	ipsetCmd    *IpsetCommandService
+	iptablesCmd *IptablesCommandService
+}
+
+// NewWatchdogMonitor creates a WatchdogMonitor.
+func NewWatchdogMonitor(logger zerolog.Logger, ipsetCmd *IpsetCommandService, iptablesCmd *IptablesCommandService) *WatchdogMonitor {
+	return &WatchdogMonitor{logger: logger, ipsetCmd: ipsetCmd, iptablesCmd: iptablesCmd}
+}
+
+// Run pings the watchdog until ctx is cancelled. If systemd didn't enable
+// the watchdog for this unit (no WatchdogSec=), Run returns immediately.
+func (m *WatchdogMonitor) Run(ctx context.Context) error {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		return fmt.Errorf("failed to read watchdog interval: %w", err)
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !m.selfCheck() {
+				m.logger.Warn().Msg("Самопроверка watchdog не пройдена, WATCHDOG=1 не отправлен")
+				continue
+			}
+			if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+				m.logger.Warn().Err(err).Msg("Не удалось отправить WATCHDOG=1")
+			}
+		}
+	}
+}
+
+// selfCheck reports whether the blocklist is still in a sane state: both
+// ipsets present and SCANNERS-BLOCK still jumped to from INPUT in both
+// iptables and ip6tables.
+func (m *WatchdogMonitor) selfCheck() bool {
+	if !m.ipsetCmd.Exists(ipsetV4Name) || !m.ipsetCmd.Exists(ipsetV6Name) {
+		return false
+	}
+	if !m.iptablesCmd.RuleExists(IPv4, TableFilter, string(ChainInput), []string{"-j", chainName}) {
+		return false
+	}
+	if !m.iptablesCmd.RuleExists(IPv6, TableFilter, string(ChainInput), []string{"-j", chainName}) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// reconcileTarget is a chain TrafficGuard jumps into that SCANNERS-BLOCK
+// must occupy position 1 of.
+type reconcileTarget struct {
+	version IPVersion
+	chain   string
+}
+
+// ChainReconciler periodically verifies that SCANNERS-BLOCK sits at position
+// 1 of INPUT/ufw-before-input/ufw6-before-input and repairs drift, replacing
+// the systemd antiscan-move-rules.service one-shot hack with a long-running
+// watcher. It follows the "bounded frequency runner" shape from Kubernetes'
+// util/async: trigger events are coalesced, a run never happens more often
+// than minInterval, and one is forced at least every maxInterval.
+type ChainReconciler struct {
+	logger      zerolog.Logger
+	cmdSvc      *CommandService
+	iptablesCmd *IptablesCommandService
+	targets     []reconcileTarget
+
+	// ruleSet, if set via SetChainRuleSet, is reconciled against live state
+	// through IptablesCommandService.Apply on every run alongside the
+	// jump-position check above - this is what catches the SCANNERS-BLOCK
+	// chain's own rules being wiped wholesale, not just its position.
+	ruleSet RuleSet
+
+	minInterval time.Duration
+	maxInterval time.Duration
+
+	trigger chan struct{}
+}
+
+// NewChainReconciler creates a reconciler that keeps chainName at position 1
+// of INPUT, ufw-before-input and ufw6-before-input.
+func NewChainReconciler(logger zerolog.Logger, cmdSvc *CommandService, iptablesCmd *IptablesCommandService, minInterval, maxInterval time.Duration) *ChainReconciler {
+	return &ChainReconciler{
+		logger:      logger,
+		cmdSvc:      cmdSvc,
+		iptablesCmd: iptablesCmd,
+		targets: []reconcileTarget{
+			{version: IPv4, chain: string(ChainInput)},
+			{version: IPv4, chain: "ufw-before-input"},
+			{version: IPv6, chain: "ufw6-before-input"},
+		},
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		trigger:     make(chan struct{}, 1),
+	}
+}
+
+// SetChainRuleSet registers the rule set runOnce should reconcile via
+// IptablesCommandService.Apply, on top of the fixed jump-position check.
+// Must be called before Run. A zero-value RuleSet (no Table set) disables
+// this check - the default if SetChainRuleSet is never called.
+func (r *ChainReconciler) SetChainRuleSet(rs RuleSet) {
+	r.ruleSet = rs
+}
+
+// Trigger requests a reconciliation pass as soon as minInterval allows.
+// Multiple calls between runs are coalesced into a single pass.
+func (r *ChainReconciler) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run blocks, watching /etc/ufw/*.rules via inotify (falling back to relying
+// solely on the forced maxInterval tick if the watch can't be set up) and
+// reconciling on every trigger, until ctx is cancelled.
+func (r *ChainReconciler) Run(ctx context.Context) error {
+	if watcher, err := r.watchUFWRules(ctx); err != nil {
+		r.logger.Warn().Err(err).Msg("Не удалось включить fsnotify для /etc/ufw, используется только периодический тик")
+	} else {
+		defer watcher.Close()
+	}
+
+	minTimer := time.NewTimer(r.minInterval)
+	maxTimer := time.NewTimer(r.maxInterval)
+	defer minTimer.Stop()
+	defer maxTimer.Stop()
+
+	pending := true // always do an initial pass
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-r.trigger:
+			pending = true
+
+		case <-minTimer.C:
+			if pending {
+				r.runOnce()
+				pending = false
+				if !maxTimer.Stop() {
+					<-maxTimer.C
+				}
+				maxTimer.Reset(r.maxInterval)
+			}
+			minTimer.Reset(r.minInterval)
+
+		case <-maxTimer.C:
+			r.runOnce()
+			pending = false
+			maxTimer.Reset(r.maxInterval)
+		}
+	}
+}
+
+// watchUFWRules triggers a reconciliation whenever /etc/ufw/*.rules changes,
+// which is when "ufw reload"/"ufw enable" could have wiped our jump rule.
+func (r *ChainReconciler) watchUFWRules(ctx context.Context) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add("/etc/ufw"); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					r.Trigger()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Warn().Err(err).Msg("Ошибка fsnotify при наблюдении за /etc/ufw")
+			}
+		}
+	}()
+
+	return watcher, nil
+}
+
+// runOnce reconciles every target, logging but not failing the loop on
+// per-target errors so a single misbehaving chain doesn't stop the watcher.
+func (r *ChainReconciler) runOnce() {
+	for _, target := range r.targets {
+		if err := r.reconcileTarget(target); err != nil {
+			r.logger.Warn().
+				Str("chain", target.chain).
+				Str("version", string(target.version)).
+				Err(err).
+				Msg("Не удалось восстановить позицию SCANNERS-BLOCK")
+		}
+	}
+
+	if r.ruleSet.Table == "" {
+		return
+	}
+	if err := r.iptablesCmd.Apply(r.ruleSet); err != nil {
+		r.logger.Warn().Err(err).Msg("Не удалось восстановить правила SCANNERS-BLOCK")
+	}
+}
+
+// reconcileTarget makes sure chainName contains "-j SCANNERS-BLOCK" as its
+// very first rule, moving it there if it drifted or re-inserting it if it
+// was removed entirely.
+func (r *ChainReconciler) reconcileTarget(target reconcileTarget) error {
+	if !r.iptablesCmd.ChainExists(target.version, TableFilter, target.chain) {
+		// UFW/INPUT chain simply isn't present on this host for this family.
+		return nil
+	}
+
+	line, err := r.iptablesCmd.RuleLineNumber(target.version, TableFilter, target.chain, chainName)
+	if err != nil {
+		return err
+	}
+
+	if line == 1 {
+		return nil
+	}
+
+	r.logger.Warn().
+		Str("chain", target.chain).
+		Str("version", string(target.version)).
+		Int("found_at", line).
+		Msg("SCANNERS-BLOCK не на позиции 1, восстанавливаем")
+
+	if line > 0 {
+		if err := r.iptablesCmd.DeleteRuleByNumber(target.version, TableFilter, target.chain, line); err != nil {
+			return err
+		}
+	}
+
+	return r.iptablesCmd.InsertRule(target.version, TableFilter, target.chain, 1, []string{"-j", chainName})
+}
+
+// CreateWatchService installs and enables the antiscan-watch.service unit
+// that runs "antiscan watch" persistently under systemd.
+func (r *ChainReconciler) CreateWatchService() error {
+	r.logger.Info().Msg("Создание systemd сервиса для antiscan watch")
+
+	if err := os.WriteFile(TrafficGuardServicePath, []byte(TrafficGuardServiceTemplate), 0644); err != nil {
+		return fmt.Errorf("failed to create systemd service: %w", err)
+	}
+	r.logger.Info().Str("path", TrafficGuardServicePath).Msg("Создан systemd сервис")
+
+	if err := r.cmdSvc.DaemonReload(); err != nil {
+		r.logger.Warn().Err(err).Msg("Не удалось перезагрузить демон systemd")
+	}
+
+	if err := r.cmdSvc.EnableService("antiscan-watch.service"); err != nil {
+		return fmt.Errorf("failed to enable service: %w", err)
+	}
+	r.logger.Info().Msg("Сервис systemd успешно включен")
+
+	return nil
+}
+
+// RemoveWatchService stops, disables and removes the antiscan-watch.service
+// unit CreateWatchService installs. Best-effort, mirroring Teardown on the
+// other services: a service that's already gone isn't an error.
+func (r *ChainReconciler) RemoveWatchService() error {
+	r.logger.Info().Msg("Удаление systemd сервиса antiscan watch")
+
+	if err := r.cmdSvc.Run("systemctl", "stop", "antiscan-watch.service"); err != nil {
+		r.logger.Debug().Err(err).Msg("antiscan-watch уже остановлен")
+	}
+	if err := r.cmdSvc.Run("systemctl", "disable", "antiscan-watch.service"); err != nil {
+		r.logger.Debug().Err(err).Msg("antiscan-watch уже отключен")
+	}
+	if err := os.Remove(TrafficGuardServicePath); err != nil && !os.IsNotExist(err) {
+		r.logger.Warn().Err(err).Str("path", TrafficGuardServicePath).Msg("Не удалось удалить файл")
+	}
+	if err := r.cmdSvc.DaemonReload(); err != nil {
+		r.logger.Warn().Err(err).Msg("Не удалось перезагрузить демон systemd")
+	}
+
+	return nil
+}
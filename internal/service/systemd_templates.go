@@ -40,181 +40,47 @@ ExecStart=/usr/sbin/ip6tables -I ufw6-before-input 1 -j SCANNERS-BLOCK
 WantedBy=multi-user.target
 `
 
-	// AggregateLogsServiceTemplate is the systemd service for log aggregation
+	// AggregateLogsServiceTemplate is the systemd service running the
+	// native Go aggregator daemon (internal/service/aggregator), replacing
+	// the old oneshot-bash-script-plus-timer pair: the daemon stays
+	// running and aggregates on its own internal ticker instead of being
+	// invoked every 30 seconds.
 	AggregateLogsServiceTemplate = `[Unit]
 Description=TrafficGuard Log Aggregator
 After=rsyslog.service
 
 [Service]
-Type=oneshot
-ExecStart=/usr/local/bin/antiscan-aggregate-logs.sh
+Type=simple
+ExecStart=/usr/local/bin/antiscan aggregate
+Restart=on-failure
+RestartSec=5
 StandardOutput=journal
 StandardError=journal
-`
-
-	// AggregateLogsTimerTemplate is the systemd timer for log aggregation
-	AggregateLogsTimerTemplate = `[Unit]
-Description=TrafficGuard Log Aggregator Timer
-Requires=antiscan-aggregate.service
-
-[Timer]
-OnBootSec=1min
-OnUnitActiveSec=30sec
-AccuracySec=5sec
 
 [Install]
-WantedBy=timers.target
+WantedBy=multi-user.target
 `
 
-	// AggregateLogsScriptTemplate is the bash script for log aggregation
-	AggregateLogsScriptTemplate = `#!/bin/bash
-# TrafficGuard Log Aggregation Script
-# Aggregates iptables logs into CSV format with ASN/netname lookup
-#
-# Output CSV format: IP_TYPE|IP_ADDRESS|ASN|NETNAME|COUNT|LAST_SEEN
-# Example: v4|1.2.3.4|AS12345|EXAMPLE-NET|42|2026-01-26T12:34:56
-#
-# Features:
-# - Whois lookup with caching (RIPE database with auto-referrals)
-# - Atomic log rotation (grab -> clear -> process)
-# - Merges with existing data and sorts by count
-
-set -uo pipefail
-
-# Configuration
-IPV4_LOG="/var/log/iptables-scanners-ipv4.log"
-IPV6_LOG="/var/log/iptables-scanners-ipv6.log"
-OUTPUT_CSV="/var/log/iptables-scanners-aggregate.csv"
-WHOIS_CACHE="/tmp/antiscan-whois-cache.txt"
-TEMP_IPV4="/tmp/antiscan-ipv4-$$.tmp"
-TEMP_IPV6="/tmp/antiscan-ipv6-$$.tmp"
-
-# Create whois cache if doesn't exist, clean if older than 1 day
-if [ -f "$WHOIS_CACHE" ]; then
-    # Remove cache if older than 1 day
-    find "$WHOIS_CACHE" -mtime +1 -delete 2>/dev/null || true
-fi
-touch "$WHOIS_CACHE"
-
-# Grab content and immediately clear (atomic as possible)
-if [ -f "$IPV4_LOG" ]; then
-    cat "$IPV4_LOG" > "$TEMP_IPV4"
-    > "$IPV4_LOG"
-    chown syslog:adm "$IPV4_LOG" 2>/dev/null || true
-    chmod 640 "$IPV4_LOG" 2>/dev/null || true
-fi
-
-if [ -f "$IPV6_LOG" ]; then
-    cat "$IPV6_LOG" > "$TEMP_IPV6"
-    > "$IPV6_LOG"
-    chown syslog:adm "$IPV6_LOG" 2>/dev/null || true
-    chmod 640 "$IPV6_LOG" 2>/dev/null || true
-fi
-
-# Function to get ASN and netname from IP with caching
-get_ip_info() {
-    local ip="$1"
-
-    # Check cache first
-    local cached=$(grep "^${ip}|" "$WHOIS_CACHE" 2>/dev/null | head -1)
-    if [ -n "$cached" ]; then
-        # Return cached result (format: IP|ASN|NETNAME)
-        echo "$cached" | cut -d'|' -f2-
-        return
-    fi
-
-    local asn=""
-    local netname=""
-
-    # Always use RIPE (most comprehensive database with auto-referrals)
-    local whois_server="whois.ripe.net"
-
-    # Try whois lookup with timeout
-    local whois_output=$(timeout 3 whois -h "$whois_server" "$ip" 2>/dev/null || echo "")
-
-    if [ -n "$whois_output" ]; then
-        # Extract ASN from origin: line only
-        asn=$(echo "$whois_output" | grep -iE "^origin:" | head -1 | awk '{print $2}' | sed 's/AS//gi' | tr -d '\r\n ')
-
-        # Extract netname from netname: line only
-        netname=$(echo "$whois_output" | grep -iE "^netname:" | head -1 | awk '{print $2}' | tr -d '\r\n')
-    fi
-
-    # Validate ASN is numeric
-    if [ -n "$asn" ] && ! echo "$asn" | grep -qE '^[0-9]+$'; then
-        asn=""
-    fi
-
-    # If empty, set defaults
-    [ -z "$asn" ] && asn="UNKNOWN"
-    [ -z "$netname" ] && netname="UNKNOWN"
-
-    # Add AS prefix if missing
-    if [ "$asn" != "UNKNOWN" ] && ! echo "$asn" | grep -q "^AS"; then
-        asn="AS${asn}"
-    fi
-
-    # Save to cache
-    echo "${ip}|${asn}|${netname}" >> "$WHOIS_CACHE"
-
-    echo "${asn}|${netname}"
-}
-
-# Create CSV header if file doesn't exist
-if [ ! -f "$OUTPUT_CSV" ]; then
-    echo "IP_TYPE|IP_ADDRESS|ASN|NETNAME|COUNT|LAST_SEEN" > "$OUTPUT_CSV"
-fi
-
-# Process grabbed logs
-TEMP_NEW="/tmp/antiscan-new-$$.tmp"
-> "$TEMP_NEW"
-
-if [ -f "$TEMP_IPV4" ] && [ -s "$TEMP_IPV4" ]; then
-    grep 'ANTISCAN-v4:' "$TEMP_IPV4" | grep -oE 'SRC=[0-9.]+' | sed 's/SRC=//' | sort | uniq -c | while read cnt ip; do
-        # Get timestamp for this IP (last occurrence)
-        tm=$(grep "SRC=$ip" "$TEMP_IPV4" | tail -1 | awk '{print $1}')
-        info=$(get_ip_info "$ip")
-        echo "v4|${ip}|${info}|${cnt}|${tm}" >> "$TEMP_NEW"
-    done
-fi
-
-if [ -f "$TEMP_IPV6" ] && [ -s "$TEMP_IPV6" ]; then
-    grep 'ANTISCAN-v6:' "$TEMP_IPV6" | grep -oE 'SRC=[0-9a-fA-F:]+' | sed 's/SRC=//' | sort | uniq -c | while read cnt ip; do
-        # Get timestamp for this IP (last occurrence)
-        tm=$(grep "SRC=$ip" "$TEMP_IPV6" | tail -1 | awk '{print $1}')
-        info=$(get_ip_info "$ip")
-        echo "v6|${ip}|${info}|${cnt}|${tm}" >> "$TEMP_NEW"
-    done
-fi
-
-# Merge with existing CSV if there's new data
-if [ -s "$TEMP_NEW" ]; then
-    {
-        echo "IP_TYPE|IP_ADDRESS|ASN|NETNAME|COUNT|LAST_SEEN"
-        cat "$OUTPUT_CSV" "$TEMP_NEW" | awk -F'|' '
-        NR==1 { next }
-        NF==6 {
-            key = $1 "|" $2
-            count[key] += $5
-            time[key] = $6
-            asn[key] = $3
-            netname[key] = $4
-        }
-        END {
-            for (k in count) {
-                split(k, p, "|")
-                print p[1] "|" p[2] "|" asn[k] "|" netname[k] "|" count[k] "|" time[k]
-            }
-        }' | sort -t'|' -k5 -nr
-    } > "${OUTPUT_CSV}.new"
-
-    mv "${OUTPUT_CSV}.new" "$OUTPUT_CSV"
-fi
+	// TrafficGuardServiceTemplate is the systemd service for "antiscan
+	// watch", the long-running process that keeps SCANNERS-BLOCK linked and
+	// at position 1. Type=notify lets systemd wait for READY=1 before
+	// considering the unit started, and WatchdogSec= has it restart the
+	// service if the WatchdogMonitor's self-check stops succeeding.
+	TrafficGuardServiceTemplate = `[Unit]
+Description=TrafficGuard Chain Watchdog
+After=network.target
 
-# Cleanup
-rm -f "$TEMP_NEW" "$TEMP_IPV4" "$TEMP_IPV6"
+[Service]
+Type=notify
+ExecStart=/usr/local/bin/antiscan watch
+Restart=on-failure
+RestartSec=5
+WatchdogSec=30
+StandardOutput=journal
+StandardError=journal
 
-exit 0
+[Install]
+WantedBy=multi-user.target
 `
 
 	// RsyslogConfigTemplate is the rsyslog configuration for iptables logging
@@ -255,8 +121,7 @@ const (
 	IpsetRestoreServicePath  = "/etc/systemd/system/antiscan-ipset-restore.service"
 	MoveRulesServicePath     = "/etc/systemd/system/antiscan-move-rules.service"
 	AggregateLogsServicePath = "/etc/systemd/system/antiscan-aggregate.service"
-	AggregateLogsTimerPath   = "/etc/systemd/system/antiscan-aggregate.timer"
-	AggregateLogsScriptPath  = "/usr/local/bin/antiscan-aggregate-logs.sh"
+	TrafficGuardServicePath  = "/etc/systemd/system/antiscan-watch.service"
 	RsyslogConfigPath        = "/etc/rsyslog.d/10-iptables-scanners.conf"
 	LogrotateConfigPath      = "/etc/logrotate.d/iptables-scanners"
 )
@@ -301,4 +166,5 @@ const (
 	IPv4LogPath      = "/var/log/iptables-scanners-ipv4.log"
 	IPv6LogPath      = "/var/log/iptables-scanners-ipv6.log"
 	AggregateLogPath = "/var/log/iptables-scanners-aggregate.csv"
+	WhoisCachePath   = "/var/lib/antiscan/whois-cache.db"
 )